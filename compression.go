@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressions holds magic numbers for supported compression formats
+var compressions = []struct {
+	Name  string
+	Magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"bzip2", []byte("BZh")},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// detectCompression returns the compression format matching head magic
+// number, or an empty string if the content is not compressed
+func detectCompression(head []byte) string {
+	for _, c := range compressions {
+		if bytes.HasPrefix(head, c.Magic) {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// fileCompression returns the compression format used for file
+func fileCompression(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 6)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return detectCompression(head[:n]), nil
+}
+
+// decompress sniffs r content and returns a reader streaming decompressed
+// content along with the detected compression format
+// If r is not compressed, the returned reader yields r content unchanged
+// The returned reader must be closed once read to release decompression
+// resources; r is not closed
+func decompress(r io.Reader) (io.ReadCloser, string, error) {
+	br := bufio.NewReader(r)
+
+	// Peek errors are not fatal: short or empty inputs are not compressed
+	head, _ := br.Peek(6)
+	compression := detectCompression(head)
+
+	var (
+		dr  io.ReadCloser
+		err error
+	)
+
+	switch compression {
+	case "gzip":
+		dr, err = gzip.NewReader(br)
+	case "bzip2":
+		dr = ioutil.NopCloser(bzip2.NewReader(br))
+	case "xz":
+		var xr *xz.Reader
+		xr, err = xz.NewReader(br)
+		dr = ioutil.NopCloser(xr)
+	case "zstd":
+		var zr *zstd.Decoder
+		zr, err = zstd.NewReader(br)
+		if err == nil {
+			dr = zr.IOReadCloser()
+		}
+	default:
+		dr = ioutil.NopCloser(br)
+	}
+
+	if err != nil {
+		return nil, compression, fmt.Errorf("unable to read %s stream: %v", compression, err)
+	}
+
+	return dr, compression, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+// containerLogFormats holds supported container log envelopes
+var containerLogFormats = []string{"auto", "none", "docker", "cri", "journald"}
+
+// crire matches CRI log lines (containerd, CRI-O)
+// e.g. "2024-01-01T00:00:00.000000000Z stdout F # Time: ..."
+var crire = regexp.MustCompile(`^(\S+) (stdout|stderr) ([PF])(?: (.*))?$`)
+
+// dockerLogLine holds a Docker json-file log line
+type dockerLogLine struct {
+	Log    *string `json:"log"`
+	Stream string  `json:"stream"`
+}
+
+// journaldRecord holds a `journalctl -o json` record
+// MESSAGE is an array of bytes when it is not valid UTF-8
+type journaldRecord struct {
+	Message   json.RawMessage `json:"MESSAGE"`
+	LineBreak string          `json:"_LINE_BREAK"`
+}
+
+// containerUnwrapper extracts content from an envelope line
+// Partial content is continued by the next line of the same stream
+type containerUnwrapper func(line []byte) (stream string, content []byte, partial bool, ok bool)
+
+// checkContainerLogFormat returns an error if format is not supported
+func checkContainerLogFormat(format string) error {
+	for _, f := range containerLogFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown container log format %s", format)
+}
+
+// unwrapContainerLog strips container logging envelopes (Docker json-file,
+// CRI, journald JSON) from r so readers get raw log lines
+// With "auto" (or an empty format), envelope is detected on first line
+// The returned reader must be closed once read, so unwrapping stops when its
+// consumer returns early
+func unwrapContainerLog(r io.Reader, format, source string) io.ReadCloser {
+	if format == "none" {
+		return ioutil.NopCloser(r)
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	if format == "auto" || format == "" {
+		format = detectContainerLog(inputs.PeekLine(br))
+		if format == "none" {
+			return ioutil.NopCloser(br)
+		}
+		log.Infof("unwrapping %s container log for %s", format, source)
+	}
+
+	var unwrap containerUnwrapper
+
+	switch format {
+	case "docker":
+		unwrap = unwrapDocker
+	case "cri":
+		unwrap = unwrapCRI
+	case "journald":
+		unwrap = unwrapJournald
+	default:
+		return ioutil.NopCloser(br)
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		// Envelopes longer than inputs.MaxLineSize can not be decoded; they
+		// are skipped so the rest of the log is still read
+		scanner, splitter := inputs.NewLineScanner(br)
+		read := 0
+		truncated := 0
+
+		// Partial lines are reassembled per stream
+		pending := map[string][]byte{}
+		order := []string{}
+
+		for scanner.Scan() {
+			read++
+			if splitter.Truncated {
+				log.Debugf("container log line %d exceeds %d bytes; skipping", read, inputs.MaxLineSize)
+				truncated++
+				continue
+			}
+
+			stream, content, partial, ok := unwrap(scanner.Bytes())
+			if !ok {
+				// Not wrapped, pass through
+				stream, content, partial = "", scanner.Bytes(), false
+			}
+
+			if _, seen := pending[stream]; !seen {
+				order = append(order, stream)
+			}
+			pending[stream] = append(pending[stream], content...)
+
+			if partial {
+				continue
+			}
+
+			if _, err := pw.Write(append(pending[stream], '\n')); err != nil {
+				return
+			}
+			pending[stream] = pending[stream][:0]
+		}
+
+		// Ship incomplete lines left
+		for _, stream := range order {
+			if len(pending[stream]) > 0 {
+				if _, err := pw.Write(append(pending[stream], '\n')); err != nil {
+					return
+				}
+			}
+		}
+
+		if truncated > 0 {
+			log.Warnf("skipped %d container log lines longer than %d bytes in %s", truncated, inputs.MaxLineSize, source)
+		}
+
+		pw.CloseWithError(scanner.Err())
+	}()
+
+	return pr
+}
+
+// detectContainerLog returns the container log format of line
+func detectContainerLog(line []byte) string {
+	if crire.Match(line) {
+		return "cri"
+	}
+
+	if len(line) == 0 || line[0] != '{' {
+		return "none"
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return "none"
+	}
+
+	if _, ok := fields["log"]; ok {
+		return "docker"
+	}
+	if _, ok := fields["MESSAGE"]; ok {
+		return "journald"
+	}
+
+	return "none"
+}
+
+// unwrapDocker extracts content from Docker json-file lines
+// Lines longer than 16k are split; only the last part ends with '\n'
+func unwrapDocker(line []byte) (string, []byte, bool, bool) {
+	var l dockerLogLine
+	if err := json.Unmarshal(line, &l); err != nil || l.Log == nil {
+		return "", nil, false, false
+	}
+
+	content := []byte(*l.Log)
+	if bytes.HasSuffix(content, []byte("\n")) {
+		return l.Stream, bytes.TrimSuffix(bytes.TrimSuffix(content, []byte("\n")), []byte("\r")), false, true
+	}
+
+	return l.Stream, content, true, true
+}
+
+// unwrapCRI extracts content from CRI lines
+// "P" tagged lines are partial, the line ends with the next "F" tagged one
+func unwrapCRI(line []byte) (string, []byte, bool, bool) {
+	m := crire.FindSubmatch(line)
+	if m == nil {
+		return "", nil, false, false
+	}
+
+	return string(m[2]), m[4], string(m[3]) == "P", true
+}
+
+// unwrapJournald extracts content from journald JSON records
+func unwrapJournald(line []byte) (string, []byte, bool, bool) {
+	var rec journaldRecord
+	if err := json.Unmarshal(line, &rec); err != nil || rec.Message == nil {
+		return "", nil, false, false
+	}
+
+	var content []byte
+
+	var s string
+	if err := json.Unmarshal(rec.Message, &s); err == nil {
+		content = []byte(s)
+	} else {
+		var b []int
+		if err := json.Unmarshal(rec.Message, &b); err != nil {
+			return "", nil, false, false
+		}
+		content = make([]byte, len(b))
+		for i, c := range b {
+			content[i] = byte(c)
+		}
+	}
+
+	// journald splits lines longer than LineMax
+	return "", content, rec.LineBreak == "line-max", true
+}
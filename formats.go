@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+// checkInputFormat returns an error if format is neither "auto" nor a
+// registered format
+func checkInputFormat(format string) error {
+	if format == "auto" {
+		return nil
+	}
+	if _, ok := inputs.Inputs[format]; !ok {
+		return fmt.Errorf("unknown input format %s; see `--list-inputs`", format)
+	}
+	return nil
+}
+
+// checkMultiStatements returns an error if mode is not a valid
+// --multi-statements value
+func checkMultiStatements(mode string) error {
+	for _, m := range inputs.StatementsModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown multi-statements mode %s", mode)
+}
+
+// sniffReader returns the reader for source content in r, detecting format
+// when it is "auto", along with a reader to use instead of r
+func sniffReader(r io.Reader, format, source string) (inputs.Reader, io.Reader) {
+	if format != "auto" {
+		return inputs.Inputs[format], r
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	format = sniffInputFormat(br)
+	log.Infof(`using "%s" input format for %s`, format, source)
+
+	return inputs.Inputs[format], br
+}
+
+// sniffInputFormat detects input format from the beginning of br, without
+// consuming it
+func sniffInputFormat(br *bufio.Reader) string {
+	format := inputs.Sniff(br)
+
+	// Historical default
+	if format == "" {
+		return "slowlog"
+	}
+
+	return format
+}
+
+// recognizedFile returns true if file content, once decompressed & unwrapped
+// from its container log envelope, is empty or in a known input format
+// Files that can not be read are reported when read, so they are recognized
+func recognizedFile(name string) bool {
+	file, err := os.Open(name)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+
+	r, _, err := decompress(file)
+	if err != nil {
+		return true
+	}
+	defer r.Close()
+
+	unwrapped := unwrapContainerLog(r, Config.ContainerLog, name)
+	defer unwrapped.Close()
+
+	br := bufio.NewReaderSize(unwrapped, 64*1024)
+	if _, err := br.Peek(1); err != nil {
+		return true
+	}
+
+	return inputs.Sniff(br) != ""
+}
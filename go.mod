@@ -3,12 +3,15 @@ module gitlab.com/devopsworks/tools/dw-query-digest
 require (
 	github.com/Preetam/mysqllog v0.3.0 // indirect
 	github.com/fatih/color v1.7.0 // indirect
+	github.com/google/gopacket v1.1.19
+	github.com/klauspost/compress v1.11.13
 	github.com/mattn/go-colorable v0.0.9 // indirect
 	github.com/mattn/go-isatty v0.0.4 // indirect
 	github.com/mattn/go-runewidth v0.0.4 // indirect
 	github.com/nxadm/tail v1.4.4
 	github.com/sirupsen/logrus v1.2.0
 	github.com/stretchr/testify v1.2.2
+	github.com/ulikunitz/xz v0.5.8
 	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550 // indirect
 	golang.org/x/exp v0.0.0-20181112044915-a3060d491354 // indirect
 	golang.org/x/lint v0.0.0-20201208152925-83fdc39ff7b5 // indirect
@@ -0,0 +1,15 @@
+package all
+
+import (
+	// No need to name imports here
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/audit"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/binlog"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/cloudwatch"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/digest"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/generallog"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/pcap"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/postgres"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/proxysql"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/slowlog"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/tidb"
+)
@@ -0,0 +1,454 @@
+// Package audit reads MySQL Enterprise, Percona Server & MariaDB audit logs
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+// auditUnescaper unescapes MariaDB server_audit query text
+var auditUnescaper = strings.NewReplacer(`\'`, `'`, `\"`, `"`, `\\`, `\`, `\n`, "\n", `\r`, "\r", `\t`, "\t")
+
+// auditcsvre matches MariaDB server_audit lines
+// e.g. "20201012 10:34:01,db1,root,localhost,31,103,QUERY,test,'select 1',0"
+var auditcsvre = regexp.MustCompile(`(?m)^\d{8} +\d{1,2}:\d{2}:\d{2},[^,\n]*,[^,\n]*,[^,\n]*,\d+,\d+,[A-Z_]+,`)
+
+// auditJSONRecord holds a JSON audit record, either from Percona audit_log
+// (audit_log_format=JSON) or from MySQL Enterprise Audit (JSON format)
+type auditJSONRecord struct {
+	// Percona
+	AuditRecord map[string]interface{} `json:"audit_record"`
+
+	// MySQL Enterprise
+	Timestamp    string `json:"timestamp"`
+	ConnectionID int    `json:"connection_id"`
+	Class        string `json:"class"`
+	Account      struct {
+		User string `json:"user"`
+		Host string `json:"host"`
+	} `json:"account"`
+	Login struct {
+		IP string `json:"ip"`
+	} `json:"login"`
+	GeneralData *struct {
+		Command string `json:"command"`
+		Query   string `json:"query"`
+		Status  int    `json:"status"`
+	} `json:"general_data"`
+	QueryStatistics *struct {
+		QueryTime    float64 `json:"query_time"`
+		RowsSent     int     `json:"rows_sent"`
+		RowsExamined int     `json:"rows_examined"`
+	} `json:"query_statistics"`
+}
+
+// auditXMLRecord holds an XML audit record, with fields as attributes (OLD
+// format) or as child elements (NEW format)
+type auditXMLRecord struct {
+	Attrs  []xml.Attr `xml:",any,attr"`
+	Fields []struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	} `xml:",any"`
+}
+
+// Reader reads audit logs
+type Reader struct{}
+
+func init() {
+	inputs.Add("audit", Reader{})
+}
+
+// Sniff recognizes Percona, MySQL Enterprise & MariaDB audit logs
+func (Reader) Sniff(head []byte) int {
+	switch {
+	case bytes.Contains(head, []byte(`{"audit_record":`)), bytes.Contains(head, []byte("<AUDIT_RECORD")):
+		return inputs.Certain
+	case auditcsvre.Match(head):
+		return inputs.Certain
+	case bytes.Contains(head, []byte(`"class":`)) && bytes.Contains(head, []byte(`"connection_id":`)):
+		// MySQL Enterprise JSON
+		return 90
+	}
+	return 0
+}
+
+// Read reads MySQL, Percona Server or MariaDB audit logs and sends
+// raw queries to workers
+// Format is detected from the first line: Percona JSON & MySQL Enterprise
+// JSON, Percona & MySQL Enterprise XML (OLD & NEW) or MariaDB server_audit
+// CSV
+// Audit logs do not hold timing information (unless MySQL Enterprise query
+// statistics are logged), so queries are flagged with NoTiming
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	source := src.Name
+
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	// send sends qry to workers
+	send := func(qry *inputs.Query) {
+		qry.Source = source
+		if strings.TrimSpace(qry.FullQuery) != "" {
+			events <- *qry
+		}
+	}
+
+	var (
+		read, skipped int
+		err           error
+	)
+
+	first := bytes.TrimSpace(inputs.PeekLine(br))
+	switch {
+	case bytes.HasPrefix(first, []byte("<")):
+		read, skipped, err = readAuditXML(br, src.Location, send)
+	case bytes.HasPrefix(first, []byte("[")), bytes.HasPrefix(first, []byte("{")):
+		read, skipped, err = readAuditJSON(br, src.Location, send)
+	default:
+		read, skipped, err = readAuditCSV(br, src, send)
+	}
+
+	src.Lines += read
+	src.SkippedLines += skipped
+
+	if err != nil {
+		return fmt.Errorf("after %d records: %v", read, err)
+	}
+
+	return nil
+}
+
+// readAuditJSON reads Percona or MySQL Enterprise JSON audit records
+// MySQL Enterprise writes a JSON array (not closed while the log is active),
+// Percona writes one record per line
+func readAuditJSON(r *bufio.Reader, loc *time.Location, send func(*inputs.Query)) (int, int, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	if bytes.HasPrefix(bytes.TrimSpace(inputs.PeekLine(r)), []byte("[")) {
+		// Opening bracket
+		if _, err := dec.Token(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	read := 0
+	skipped := 0
+
+	for dec.More() {
+		var rec auditJSONRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				// Truncated last record
+				return read, skipped + 1, nil
+			}
+			if inputEnded(bufio.NewReader(io.MultiReader(dec.Buffered(), r)), ',') {
+				// Unterminated array
+				return read, skipped, nil
+			}
+			return read, skipped, err
+		}
+		read++
+
+		var qry *inputs.Query
+		if rec.AuditRecord != nil {
+			fields := map[string]string{}
+			for k, v := range rec.AuditRecord {
+				fields[strings.ToUpper(k)] = fmt.Sprint(v)
+			}
+			qry = auditRecordQuery(fields, loc)
+		} else {
+			qry = rec.query(loc)
+		}
+
+		if qry != nil {
+			send(qry)
+		}
+	}
+
+	return read, skipped, nil
+}
+
+// inputEnded returns true if nothing but spaces & a single separator (if not
+// 0) are left in r after a decoding error, i.e. the input ended between two
+// records
+// Reading stops at the first other byte, so the rest of the input is never
+// loaded
+func inputEnded(r *bufio.Reader, separator byte) bool {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return err == io.EOF
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		case c == separator && separator != 0:
+			separator = 0
+		default:
+			r.UnreadByte()
+			return false
+		}
+	}
+}
+
+// query returns the query from a MySQL Enterprise JSON record, or nil if the
+// record is not a query
+func (rec auditJSONRecord) query(loc *time.Location) *inputs.Query {
+	if rec.Class != "general" || rec.GeneralData == nil || rec.GeneralData.Query == "" {
+		return nil
+	}
+
+	switch rec.GeneralData.Command {
+	case "Query", "Execute":
+	default:
+		return nil
+	}
+
+	qry := &inputs.Query{
+		Time:         parseAuditTime(rec.Timestamp, loc),
+		User:         rec.Account.User,
+		Client:       rec.Login.IP,
+		ConnectionID: rec.ConnectionID,
+		LastErrno:    rec.GeneralData.Status,
+		FullQuery:    rec.GeneralData.Query,
+		NoTiming:     true,
+	}
+
+	if qry.Client == "" {
+		qry.Client = rec.Account.Host
+	}
+
+	if stats := rec.QueryStatistics; stats != nil {
+		qry.NoTiming = false
+		qry.QueryTime = stats.QueryTime
+		qry.RowsSent = stats.RowsSent
+		qry.RowsExamined = stats.RowsExamined
+	}
+
+	return qry
+}
+
+// readAuditXML reads Percona or MySQL Enterprise XML audit records
+// The closing </AUDIT> tag is missing while the log is active
+// A corrupt record is skipped and decoding resumes on the next one
+func readAuditXML(r *bufio.Reader, loc *time.Location, send func(*inputs.Query)) (int, int, error) {
+	// r is an io.ByteReader, so the decoder does not read ahead and decoding
+	// can resume from r after an error
+	dec := xml.NewDecoder(r)
+
+	read := 0
+	skipped := 0
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return read, skipped, nil
+		}
+		if err != nil {
+			if _, ok := err.(*xml.SyntaxError); !ok {
+				return read, skipped, err
+			}
+			if inputEnded(r, 0) {
+				if read > 0 {
+					// Unterminated log
+					return read, skipped, nil
+				}
+				return read, skipped, err
+			}
+
+			// Corrupt (self-closing) record
+			skipped++
+			if !skipToAuditRecord(r) {
+				return read, skipped, nil
+			}
+			dec = xml.NewDecoder(r)
+			continue
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "AUDIT_RECORD" {
+			continue
+		}
+
+		var rec auditXMLRecord
+		if err := dec.DecodeElement(&rec, &start); err != nil {
+			skipped++
+			if inputEnded(r, 0) || !skipToAuditRecord(r) {
+				// Truncated last record
+				return read, skipped, nil
+			}
+			dec = xml.NewDecoder(r)
+			continue
+		}
+		read++
+
+		fields := map[string]string{}
+		for _, attr := range rec.Attrs {
+			fields[attr.Name.Local] = attr.Value
+		}
+		for _, field := range rec.Fields {
+			fields[field.XMLName.Local] = field.Value
+		}
+
+		if qry := auditRecordQuery(fields, loc); qry != nil {
+			send(qry)
+		}
+	}
+}
+
+// skipToAuditRecord discards r content up to the next <AUDIT_RECORD tag; it
+// returns false if there is none
+func skipToAuditRecord(r *bufio.Reader) bool {
+	tag := []byte("<AUDIT_RECORD")
+
+	for {
+		if _, err := r.ReadSlice('<'); err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			return false
+		}
+		r.UnreadByte()
+
+		if head, _ := r.Peek(len(tag)); bytes.Equal(head, tag) {
+			return true
+		}
+		r.Discard(1)
+	}
+}
+
+// auditRecordQuery returns the query from Percona (JSON, XML) or MySQL
+// Enterprise (XML) record fields, or nil if the record is not a query
+// e.g. NAME=Query CONNECTION_ID=10 STATUS=0 SQLTEXT="select 1"
+// USER="root[root] @ localhost [127.0.0.1]" HOST=localhost IP=127.0.0.1 DB=test
+func auditRecordQuery(fields map[string]string, loc *time.Location) *inputs.Query {
+	switch fields["NAME"] {
+	case "Query", "Execute":
+	default:
+		return nil
+	}
+
+	if fields["SQLTEXT"] == "" {
+		return nil
+	}
+
+	qry := &inputs.Query{
+		Time:      parseAuditTime(fields["TIMESTAMP"], loc),
+		User:      strings.TrimSpace(fields["USER"]),
+		Client:    fields["IP"],
+		Schema:    fields["DB"],
+		FullQuery: fields["SQLTEXT"],
+		NoTiming:  true,
+	}
+
+	qry.ConnectionID, _ = strconv.Atoi(fields["CONNECTION_ID"])
+	qry.LastErrno, _ = strconv.Atoi(fields["STATUS"])
+
+	// "priv_user[user] @ host [ip]"
+	if idx := strings.IndexAny(qry.User, "[ "); idx != -1 {
+		qry.User = qry.User[:idx]
+	}
+
+	if qry.Client == "" {
+		qry.Client = fields["HOST"]
+	}
+
+	return qry
+}
+
+// readAuditCSV reads MariaDB server_audit records
+// e.g. 20201012 10:34:01,db1,root,localhost,31,103,QUERY,test,'select 1',0
+// Lines longer than inputs.MaxLineSize are truncated; their query is kept
+// without return code & counted as truncated in src
+func readAuditCSV(r io.Reader, src *inputs.Source, send func(*inputs.Query)) (int, int, error) {
+	scanner, splitter := inputs.NewLineScanner(r)
+
+	read := 0
+	skipped := 0
+	truncatedEntries := 0
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		read++
+
+		// timestamp, serverhost, username, host, connectionid, queryid,
+		// operation, database, object & retcode; object may hold commas
+		fields := strings.SplitN(line, ",", 9)
+		if len(fields) != 9 {
+			skipped++
+			continue
+		}
+
+		object, retcode := fields[8], ""
+		if !splitter.Truncated {
+			last := strings.LastIndex(object, ",")
+			if last == -1 {
+				skipped++
+				continue
+			}
+			object, retcode = object[:last], object[last+1:]
+		}
+
+		t := parseAuditTime(fields[0], src.Location)
+		if t.IsZero() {
+			skipped++
+			continue
+		}
+
+		if !strings.HasPrefix(fields[6], "QUERY") {
+			// CONNECT, DISCONNECT, table events, ...
+			continue
+		}
+
+		if splitter.Truncated {
+			log.Debugf("line %d exceeds %d bytes; truncating", read, inputs.MaxLineSize)
+			truncatedEntries++
+			object = strings.TrimPrefix(object, "'")
+		} else if len(object) >= 2 && object[0] == '\'' && object[len(object)-1] == '\'' {
+			object = object[1 : len(object)-1]
+		}
+
+		qry := &inputs.Query{
+			Time:      t,
+			User:      fields[2],
+			Client:    fields[3],
+			Schema:    fields[7],
+			FullQuery: auditUnescaper.Replace(object),
+			NoTiming:  true,
+		}
+		qry.ConnectionID, _ = strconv.Atoi(fields[4])
+		qry.LastErrno, _ = strconv.Atoi(retcode)
+
+		send(qry)
+	}
+
+	src.TruncatedEntries += truncatedEntries
+	if truncatedEntries > 0 {
+		log.Warnf("truncated %d records with lines longer than %d bytes in %s", truncatedEntries, inputs.MaxLineSize, src.Name)
+	}
+
+	return read, skipped, scanner.Err()
+}
+
+// parseAuditTime parses an audit log timestamp
+// It returns the zero time if s can not be parsed
+// Timestamps without timezone are in loc
+func parseAuditTime(s string, loc *time.Location) time.Time {
+	t, err := inputs.ParseTime(s, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
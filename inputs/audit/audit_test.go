@@ -0,0 +1,222 @@
+package audit
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+func TestReadAuditLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		log    string
+		user   string
+		client string
+		schema string
+		errno  []int
+	}{
+		{
+			name: "percona json",
+			log: `{"audit_record":{"name":"Connect","record":"1_2020-02-26T13:54:42","timestamp":"2020-02-26T14:46:33Z","connection_id":"10","status":0,"user":"root","priv_user":"root","host":"localhost","ip":"","db":""}}
+{"audit_record":{"name":"Query","record":"2_2020-02-26T13:54:42","timestamp":"2020-02-26T14:46:34Z","command_class":"select","connection_id":"10","status":0,"sqltext":"SELECT * FROM t WHERE id = 1","user":"root[root] @ localhost [127.0.0.1]","host":"localhost","os_user":"","ip":"127.0.0.1","db":"test"}}
+{"audit_record":{"name":"Query","record":"3_2020-02-26T13:54:42","timestamp":"2020-02-26T14:46:35Z","command_class":"select","connection_id":"10","status":1146,"sqltext":"SELECT * FROM u WHERE id = 2","user":"root[root] @ localhost [127.0.0.1]","host":"localhost","os_user":"","ip":"127.0.0.1","db":"test"}}
+`,
+			user: "root", client: "127.0.0.1", schema: "test", errno: []int{0, 1146},
+		},
+		{
+			name: "percona xml new",
+			log: `<?xml version="1.0" encoding="UTF-8"?>
+<AUDIT>
+ <AUDIT_RECORD>
+  <NAME>Query</NAME>
+  <RECORD>2_2020-02-26T13:54:42</RECORD>
+  <TIMESTAMP>2020-02-26T14:46:34Z</TIMESTAMP>
+  <COMMAND_CLASS>select</COMMAND_CLASS>
+  <CONNECTION_ID>10</CONNECTION_ID>
+  <STATUS>0</STATUS>
+  <SQLTEXT>SELECT * FROM t WHERE id = 1</SQLTEXT>
+  <USER>root[root] @ localhost [127.0.0.1]</USER>
+  <HOST>localhost</HOST>
+  <OS_USER></OS_USER>
+  <IP>127.0.0.1</IP>
+  <DB>test</DB>
+ </AUDIT_RECORD>
+ <AUDIT_RECORD>
+  <NAME>Query</NAME>
+  <TIMESTAMP>2020-02-26T14:46:35Z</TIMESTAMP>
+  <CONNECTION_ID>10</CONNECTION_ID>
+  <STATUS>1146</STATUS>
+  <SQLTEXT>SELECT * FROM u WHERE id = 2</SQLTEXT>
+  <USER>root[root] @ localhost [127.0.0.1]</USER>
+  <HOST>localhost</HOST>
+  <IP>127.0.0.1</IP>
+  <DB>test</DB>
+ </AUDIT_RECORD>
+</AUDIT>
+`,
+			user: "root", client: "127.0.0.1", schema: "test", errno: []int{0, 1146},
+		},
+		{
+			name: "percona xml old, unterminated",
+			log: `<?xml version="1.0" encoding="UTF-8"?>
+<AUDIT>
+  <AUDIT_RECORD
+    NAME="Query"
+    RECORD="2_2020-02-26T13:54:42"
+    TIMESTAMP="2020-02-26T14:46:34Z"
+    COMMAND_CLASS="select"
+    CONNECTION_ID="10"
+    STATUS="0"
+    SQLTEXT="SELECT * FROM t WHERE id = 1"
+    USER="root[root] @ localhost [127.0.0.1]"
+    HOST="localhost"
+    OS_USER=""
+    IP="127.0.0.1"
+    DB="test"
+  />
+  <AUDIT_RECORD
+    NAME="Query"
+    TIMESTAMP="2020-02-26T14:46:35Z"
+    CONNECTION_ID="10"
+    STATUS="1146"
+    SQLTEXT="SELECT * FROM u WHERE id = 2"
+    USER="root[root] @ localhost [127.0.0.1]"
+    HOST="localhost"
+    IP="127.0.0.1"
+    DB="test"
+  />
+`,
+			user: "root", client: "127.0.0.1", schema: "test", errno: []int{0, 1146},
+		},
+		{
+			name: "mysql enterprise json",
+			log: `[
+{ "timestamp": "2020-02-26 14:46:33", "id": 0, "class": "connection", "event": "connect", "connection_id": 10, "account": { "user": "root", "host": "localhost" }, "login": { "user": "root", "os": "", "ip": "127.0.0.1", "proxy": "" } },
+{ "timestamp": "2020-02-26 14:46:34", "id": 1, "class": "general", "event": "status", "connection_id": 10, "account": { "user": "root", "host": "localhost" }, "login": { "user": "root", "os": "", "ip": "127.0.0.1", "proxy": "" }, "general_data": { "command": "Query", "sql_command": "select", "query": "SELECT * FROM t WHERE id = 1", "status": 0 } },
+{ "timestamp": "2020-02-26 14:46:35", "id": 2, "class": "general", "event": "status", "connection_id": 10, "account": { "user": "root", "host": "localhost" }, "login": { "user": "root", "os": "", "ip": "127.0.0.1", "proxy": "" }, "general_data": { "command": "Query", "sql_command": "select", "query": "SELECT * FROM u WHERE id = 2", "status": 1146 } },
+`,
+			user: "root", client: "127.0.0.1", errno: []int{0, 1146},
+		},
+		{
+			name: "mariadb server_audit",
+			log: `20200226 14:46:33,db1,root,127.0.0.1,10,0,CONNECT,test,,0
+20200226 14:46:34,db1,root,127.0.0.1,10,102,QUERY,test,'SELECT * FROM t WHERE id = 1',0
+20200226 14:46:34,db1,root,127.0.0.1,10,102,READ,test,t,
+20200226 14:46:35,db1,root,127.0.0.1,10,103,QUERY,test,'SELECT * FROM u WHERE id = 2',1146
+`,
+			user: "root", client: "127.0.0.1", schema: "test", errno: []int{0, 1146},
+		},
+	}
+
+	for _, tt := range tests {
+		events := make(chan inputs.Query, 10)
+
+		assert.Nil(t, Reader{}.Read(strings.NewReader(tt.log), &inputs.Source{Name: "test"}, events), tt.name)
+		close(events)
+
+		var got []inputs.Query
+		for qry := range events {
+			got = append(got, qry)
+		}
+
+		if !assert.Len(t, got, 2, tt.name) {
+			continue
+		}
+
+		assert.Equal(t, "SELECT * FROM t WHERE id = 1", got[0].FullQuery, tt.name)
+		assert.Equal(t, "SELECT * FROM u WHERE id = 2", got[1].FullQuery, tt.name)
+		assert.Equal(t, tt.user, got[0].User, tt.name)
+		assert.Equal(t, tt.client, got[0].Client, tt.name)
+		assert.Equal(t, tt.schema, got[0].Schema, tt.name)
+		assert.Equal(t, 10, got[0].ConnectionID, tt.name)
+		assert.Equal(t, "2020-02-26T14:46:34Z", got[0].Time.Format(time.RFC3339), tt.name)
+		assert.Equal(t, tt.errno, []int{got[0].LastErrno, got[1].LastErrno}, tt.name)
+		assert.True(t, got[0].NoTiming, tt.name)
+	}
+}
+
+func TestReadAuditJSONEnd(t *testing.T) {
+	record := `{ "timestamp": "2020-02-26 14:46:34", "id": 1, "class": "general", "event": "status", "connection_id": 10, "general_data": { "command": "Query", "query": "SELECT 1", "status": 0 } }`
+
+	tests := []struct {
+		name    string
+		log     string
+		read    int
+		skipped int
+		err     bool
+	}{
+		{"closed array", "[\n" + record + ",\n" + record + "\n]\n", 2, 0, false},
+		{"unterminated array", "[\n" + record + ",\n" + record + ",\n", 2, 0, false},
+		{"unterminated array without separator", "[\n" + record + ",\n" + record + "\n", 2, 0, false},
+		{"truncated record", "[\n" + record + ",\n" + record[:40], 1, 1, false},
+		{"json lines", record + "\n" + record + "\n", 2, 0, false},
+		{"invalid", "[\n" + record + ",\nnot json\n", 1, 0, true},
+	}
+
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	for _, tt := range tests {
+		hook.Reset()
+		sent := 0
+
+		read, skipped, err := readAuditJSON(bufio.NewReader(strings.NewReader(tt.log)), nil, func(*inputs.Query) { sent++ })
+
+		assert.Equal(t, tt.read, read, tt.name)
+		assert.Equal(t, tt.read, sent, tt.name)
+		assert.Equal(t, tt.skipped, skipped, tt.name)
+		assert.Equal(t, tt.err, err != nil, tt.name)
+
+		for _, entry := range hook.AllEntries() {
+			assert.True(t, entry.Level > log.ErrorLevel, "%s: logged %s", tt.name, entry.Message)
+		}
+	}
+}
+
+func TestReadAuditXMLCorrupt(t *testing.T) {
+	record := func(query string) string {
+		return " <AUDIT_RECORD>\n  <NAME>Query</NAME>\n  <TIMESTAMP>2020-02-26T14:46:34Z</TIMESTAMP>\n  <CONNECTION_ID>10</CONNECTION_ID>\n  <SQLTEXT>" + query + "</SQLTEXT>\n </AUDIT_RECORD>\n"
+	}
+	oldRecord := func(query string) string {
+		return " <AUDIT_RECORD NAME=\"Query\" TIMESTAMP=\"2020-02-26T14:46:34Z\" CONNECTION_ID=\"10\" SQLTEXT=\"" + query + "\"/>\n"
+	}
+	header := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<AUDIT>\n"
+
+	tests := []struct {
+		name    string
+		log     string
+		read    int
+		skipped int
+		last    string
+		err     bool
+	}{
+		{"closed", header + record("SELECT 1") + record("SELECT 2") + "</AUDIT>\n", 2, 0, "SELECT 2", false},
+		{"unterminated", header + record("SELECT 1") + record("SELECT 2"), 2, 0, "SELECT 2", false},
+		{"truncated record", header + record("SELECT 1") + record("SELECT 2")[:60], 1, 1, "SELECT 1", false},
+		{"corrupt record", header + record("SELECT 1") + record("SELECT 2</NAME>") + record("SELECT 3") + "</AUDIT>\n", 2, 1, "SELECT 3", false},
+		{"corrupt first record", header + record("SELECT <1") + record("SELECT 2") + record("SELECT 3"), 2, 1, "SELECT 3", false},
+		{"corrupt old record", header + oldRecord("SELECT 1") + oldRecord("SELECT \"2") + oldRecord("SELECT 3") + "</AUDIT>\n", 2, 1, "SELECT 3", false},
+		{"corrupt last record", header + record("SELECT 1") + record("SELECT 2</NAME>") + "</AUDIT>\n", 1, 1, "SELECT 1", false},
+		{"invalid", "<not xml\n", 0, 0, "", true},
+	}
+
+	for _, tt := range tests {
+		var sent []string
+
+		read, skipped, err := readAuditXML(bufio.NewReader(strings.NewReader(tt.log)), nil, func(qry *inputs.Query) { sent = append(sent, qry.FullQuery) })
+
+		assert.Equal(t, tt.read, read, tt.name)
+		assert.Equal(t, tt.read, len(sent), tt.name)
+		assert.Equal(t, tt.skipped, skipped, tt.name)
+		assert.Equal(t, tt.err, err != nil, tt.name)
+		if len(sent) > 0 {
+			assert.Equal(t, tt.last, sent[len(sent)-1], tt.name)
+		}
+	}
+}
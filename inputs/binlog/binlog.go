@@ -0,0 +1,373 @@
+// Package binlog reads MySQL binary logs decoded by mysqlbinlog
+package binlog
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+	"gitlab.com/devopsworks/tools/dw-query-digest/outputs"
+)
+
+// binlogeventre matches mysqlbinlog event headers
+// e.g. "#200226 14:46:35 server id 1  end_log_pos 291 CRC32 0x1a2b3c4d 	Query	thread_id=5	exec_time=0	error_code=0"
+var binlogeventre = regexp.MustCompile(`^#(\d{6}\s+\d{1,2}:\d{2}:\d{2}) server id \d+\s+end_log_pos (\d+).*?\t(.*)$`)
+
+// binlogrowre matches decoded row images headers
+// e.g. "### UPDATE `test`.`t`"
+var binlogrowre = regexp.MustCompile("^### (INSERT INTO|UPDATE|DELETE FROM) (`[^`]*`\\.`[^`]*`|\\S+)")
+
+// binlogDelimiter ends statements in mysqlbinlog output
+const binlogDelimiter = "/*!*/;"
+
+// binlogSessionStatements holds prefixes of statements mysqlbinlog writes to
+// replay session state; they are not reported
+var binlogSessionStatements = []string{"SET TIMESTAMP=", "SET @", "SET INSERT_ID=", "SET LAST_INSERT_ID=", "/*!"}
+
+// Reader reads mysqlbinlog output
+type Reader struct{}
+
+func init() {
+	inputs.Add("binlog", Reader{})
+}
+
+// Sniff recognizes mysqlbinlog output
+func (Reader) Sniff(head []byte) int {
+	if bytes.Contains(head, []byte("DELIMITER /*!*/;")) || binlogeventre.Match(bytes.SplitN(head, []byte("\n"), 2)[0]) {
+		return inputs.Certain
+	}
+	return 0
+}
+
+// binlogParser holds mysqlbinlog output parsing state
+type binlogParser struct {
+	src     *inputs.Source
+	queries chan<- inputs.Query
+
+	event     string    // current event type (Query, Table_map, Xid, ...)
+	eventTime time.Time // current event header time
+	eventPos  int       // current event start position
+	eventEnd  int       // current event end position
+	stmtEnd   bool      // current row event ends a statement
+
+	threadID  int
+	execTime  float64
+	errorCode int
+	schema    string
+	timestamp time.Time // last SET TIMESTAMP
+
+	statement  string        // statement being read (Query events)
+	truncated  bool          // current line was truncated
+	annotation string        // original statement of row events (Rows_query, Annotate_rows)
+	pending    *inputs.Query // row changes being counted
+	pendingKey string
+
+	// transaction being read
+	inTx    bool
+	txStart int
+	txRows  int
+}
+
+// Read reads `mysqlbinlog --base64-output=decode-rows -vv` output and
+// sends raw queries to workers
+// Statement events are reported with their exec_time & error_code. Row events
+// are reported as their original statement when available (Rows_query,
+// Annotate_rows), or as "INSERT INTO|UPDATE|DELETE FROM db.table" otherwise,
+// with changed rows as rows affected. Row events have no timing information
+// Lines longer than inputs.MaxLineSize are truncated; a truncated statement
+// ends with its line & is counted as truncated
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	scanner, splitter := inputs.NewLineScanner(r)
+
+	b := &binlogParser{src: src, queries: events}
+
+	read := 0
+	truncatedEntries := 0
+
+	for scanner.Scan() {
+		read++
+
+		b.truncated = splitter.Truncated
+		if b.truncated {
+			log.Debugf("line %d exceeds %d bytes; truncating", read, inputs.MaxLineSize)
+			truncatedEntries++
+		}
+
+		b.line(scanner.Text())
+	}
+
+	b.endEvent()
+	b.flush()
+
+	src.Lines += read
+	src.TruncatedEntries += truncatedEntries
+	if truncatedEntries > 0 {
+		log.Warnf("truncated %d lines longer than %d bytes in %s", truncatedEntries, inputs.MaxLineSize, src.Name)
+	}
+
+	return scanner.Err()
+}
+
+// line handles a mysqlbinlog output line
+func (b *binlogParser) line(line string) {
+	switch {
+	case strings.HasPrefix(line, "# at "):
+		b.endEvent()
+		b.eventPos, _ = strconv.Atoi(strings.TrimSpace(line[5:]))
+	case strings.HasPrefix(line, "###"):
+		b.row(line)
+	case strings.HasPrefix(line, "#Q> "):
+		// MariaDB Annotate_rows
+		b.annotate(line[4:])
+	case binlogeventre.MatchString(line):
+		b.startEvent(binlogeventre.FindStringSubmatch(line))
+	case strings.HasPrefix(line, "# "):
+		if b.event == "Rows_query" {
+			b.annotate(line[2:])
+		}
+	case strings.HasPrefix(line, "DELIMITER "), strings.HasPrefix(line, "#"):
+	default:
+		b.statementLine(line)
+	}
+}
+
+// startEvent handles an event header
+func (b *binlogParser) startEvent(m []string) {
+	fields := strings.Fields(m[1])
+	b.eventTime, _ = inputs.ParseTime(fields[0]+" "+fields[1], b.src.Location)
+	b.eventEnd, _ = strconv.Atoi(m[2])
+
+	attrs := strings.Split(m[3], "\t")
+	b.event = strings.TrimSpace(strings.SplitN(attrs[0], ":", 2)[0])
+	b.stmtEnd = strings.Contains(m[3], "STMT_END_F")
+
+	switch {
+	case b.event == "Query":
+		// thread_id=5	exec_time=0	error_code=0
+		for _, attr := range attrs[1:] {
+			kv := strings.SplitN(attr, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "thread_id":
+				b.threadID, _ = strconv.Atoi(kv[1])
+			case "exec_time":
+				b.execTime, _ = strconv.ParseFloat(kv[1], 64)
+			case "error_code":
+				b.errorCode, _ = strconv.Atoi(kv[1])
+			}
+		}
+	case strings.HasPrefix(b.event, "Xid"):
+		b.commit()
+	case b.event == "Rows_query", b.event == "Annotate_rows":
+		b.flush()
+		b.annotation = ""
+	}
+}
+
+// endEvent handles the end of the current event
+func (b *binlogParser) endEvent() {
+	if b.stmtEnd {
+		b.flush()
+		b.annotation = ""
+		b.stmtEnd = false
+	}
+}
+
+// annotate appends an original statement line for the next row events
+// Lines are kept verbatim
+func (b *binlogParser) annotate(line string) {
+	if b.annotation != "" {
+		b.annotation += "\n"
+	}
+	b.annotation += line
+}
+
+// statementLine handles a Query event body line
+// Statements end with the mysqlbinlog delimiter, possibly on their own line;
+// lines are kept verbatim
+func (b *binlogParser) statementLine(line string) {
+	if b.event != "Query" {
+		return
+	}
+
+	// Truncated lines lost their delimiter
+	done := strings.HasSuffix(line, binlogDelimiter) || b.truncated
+	line = strings.TrimSuffix(line, binlogDelimiter)
+
+	if b.statement != "" {
+		b.statement += "\n"
+	}
+	b.statement += line
+
+	if !done {
+		return
+	}
+
+	stmt := strings.TrimSpace(b.statement)
+	b.statement = ""
+	b.statementEnd(stmt)
+}
+
+// statementEnd handles a complete statement from a Query event
+func (b *binlogParser) statementEnd(stmt string) {
+	upper := strings.ToUpper(stmt)
+
+	switch {
+	case stmt == "":
+		return
+	case strings.HasPrefix(upper, "SET TIMESTAMP="):
+		// SET TIMESTAMP=1582728395.123456
+		ts, err := strconv.ParseFloat(stmt[len("SET TIMESTAMP="):], 64)
+		if err == nil {
+			b.timestamp = time.Unix(0, int64(ts*1e6)*int64(time.Microsecond)).UTC()
+		}
+		return
+	case strings.HasPrefix(upper, "USE "):
+		b.schema = strings.Trim(strings.TrimSpace(stmt[4:]), "`")
+		return
+	case upper == "BEGIN":
+		b.inTx = true
+		b.txStart = b.eventPos
+		b.txRows = 0
+		return
+	case upper == "COMMIT":
+		b.commit()
+		return
+	case upper == "ROLLBACK":
+		b.flush()
+		b.inTx = false
+		return
+	}
+
+	for _, prefix := range binlogSessionStatements {
+		if strings.HasPrefix(upper, prefix) {
+			return
+		}
+	}
+
+	b.flush()
+
+	qry := &inputs.Query{
+		Source:       b.src.Name,
+		Time:         b.time(),
+		ConnectionID: b.threadID,
+		Schema:       b.schema,
+		QueryTime:    b.execTime,
+		LastErrno:    b.errorCode,
+		FullQuery:    stmt,
+	}
+	b.send(qry)
+}
+
+// row handles decoded row images lines
+// Each "### INSERT INTO|UPDATE|DELETE FROM" line is a changed row
+func (b *binlogParser) row(line string) {
+	m := binlogrowre.FindStringSubmatch(line)
+	if m == nil {
+		// ### WHERE, ### SET, ###   @1=...
+		return
+	}
+
+	table := strings.Replace(m[2], "`", "", -1)
+
+	key := m[1] + " " + table
+	text := key
+	if b.annotation != "" {
+		key = b.annotation
+		text = b.annotation
+	}
+
+	if b.pending == nil || b.pendingKey != key {
+		b.flush()
+
+		schema := b.schema
+		if idx := strings.Index(table, "."); idx != -1 && (b.annotation == "" || schema == "") {
+			schema = table[:idx]
+		}
+
+		b.pendingKey = key
+		b.pending = &inputs.Query{
+			Source:       b.src.Name,
+			Time:         b.time(),
+			ConnectionID: b.threadID,
+			Schema:       schema,
+			FullQuery:    text,
+			NoTiming:     true,
+		}
+	}
+
+	b.pending.RowsAffected++
+	b.txRows++
+}
+
+// flush sends pending row changes, if any
+func (b *binlogParser) flush() {
+	if b.pending == nil {
+		return
+	}
+	b.send(b.pending)
+	b.pending = nil
+	b.pendingKey = ""
+}
+
+// commit records the current transaction size
+func (b *binlogParser) commit() {
+	b.flush()
+
+	if !b.inTx {
+		return
+	}
+	b.inTx = false
+
+	rows, size := b.txRows, b.eventEnd-b.txStart
+	b.src.UpdateServerInfo(func(meta *outputs.ServerInfo) {
+		addTransaction(meta, rows, size)
+	})
+}
+
+// addTransaction records a transaction with rows changed rows & size bytes in
+// meta
+func addTransaction(meta *outputs.ServerInfo, rows, size int) {
+	if meta.Transactions == nil {
+		meta.Transactions = &outputs.TransactionInfo{}
+	}
+
+	tx := meta.Transactions
+	tx.Count++
+	tx.CumRows += rows
+	if rows > tx.MaxRows {
+		tx.MaxRows = rows
+	}
+
+	if size > 0 {
+		tx.CumBytes += size
+		if size > tx.MaxBytes {
+			tx.MaxBytes = size
+		}
+	}
+}
+
+// time returns the current statement time
+// SET TIMESTAMP is preferred since it has a better resolution & no timezone
+// issue
+func (b *binlogParser) time() time.Time {
+	if !b.timestamp.IsZero() {
+		return b.timestamp
+	}
+	return b.eventTime
+}
+
+// send sends qry to workers
+func (b *binlogParser) send(qry *inputs.Query) {
+	if strings.TrimSpace(qry.FullQuery) == "" {
+		return
+	}
+	b.queries <- *qry
+}
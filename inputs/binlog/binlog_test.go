@@ -0,0 +1,154 @@
+package binlog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+	"gitlab.com/devopsworks/tools/dw-query-digest/outputs"
+)
+
+func TestReadBinlog(t *testing.T) {
+	binlog := "/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=1*/;\n" +
+		"DELIMITER /*!*/;\n" +
+		"# at 4\n" +
+		"#200226 14:46:34 server id 1  end_log_pos 123 CRC32 0x1a2b3c4d \tStart: binlog v 4, server v 5.7.29-log created 200226 14:46:34 at startup\n" +
+		"ROLLBACK/*!*/;\n" +
+		"# at 154\n" +
+		"#200226 14:46:35 server id 1  end_log_pos 219 CRC32 0x1a2b3c4d \tGTID\tlast_committed=0\tsequence_number=1\trbr_only=yes\n" +
+		"SET @@SESSION.GTID_NEXT= 'f9a9a8a2-5897-11ea-8f4e-0242ac110002:1'/*!*/;\n" +
+		"# at 219\n" +
+		"#200226 14:46:35 server id 1  end_log_pos 291 CRC32 0x1a2b3c4d \tQuery\tthread_id=5\texec_time=0\terror_code=0\n" +
+		"SET TIMESTAMP=1582728395/*!*/;\n" +
+		"SET @@session.pseudo_thread_id=5/*!*/;\n" +
+		"/*!\\C utf8mb4 *//*!*/;\n" +
+		"BEGIN\n" +
+		"/*!*/;\n" +
+		"# at 291\n" +
+		"#200226 14:46:35 server id 1  end_log_pos 340 CRC32 0x1a2b3c4d \tTable_map: `test`.`t` mapped to number 108\n" +
+		"# at 340\n" +
+		"#200226 14:46:35 server id 1  end_log_pos 420 CRC32 0x1a2b3c4d \tUpdate_rows: table id 108 flags: STMT_END_F\n" +
+		"### UPDATE `test`.`t`\n" +
+		"### WHERE\n" +
+		"###   @1=1 /* INT meta=0 nullable=0 is_null=0 */\n" +
+		"### SET\n" +
+		"###   @1=1 /* INT meta=0 nullable=0 is_null=0 */\n" +
+		"### UPDATE `test`.`t`\n" +
+		"### WHERE\n" +
+		"###   @1=2 /* INT meta=0 nullable=0 is_null=0 */\n" +
+		"### SET\n" +
+		"###   @1=2 /* INT meta=0 nullable=0 is_null=0 */\n" +
+		"# at 420\n" +
+		"#200226 14:46:35 server id 1  end_log_pos 451 CRC32 0x1a2b3c4d \tXid = 12\n" +
+		"COMMIT/*!*/;\n" +
+		"# at 451\n" +
+		"#200226 14:46:40 server id 1  end_log_pos 520 CRC32 0x1a2b3c4d \tQuery\tthread_id=6\texec_time=0\terror_code=0\n" +
+		"SET TIMESTAMP=1582728400/*!*/;\n" +
+		"BEGIN\n" +
+		"/*!*/;\n" +
+		"# at 520\n" +
+		"#200226 14:46:40 server id 1  end_log_pos 580 CRC32 0x1a2b3c4d \tRows_query\n" +
+		"# DELETE FROM t\n" +
+		"# WHERE id > 10\n" +
+		"# at 580\n" +
+		"#200226 14:46:40 server id 1  end_log_pos 620 CRC32 0x1a2b3c4d \tTable_map: `test`.`t` mapped to number 108\n" +
+		"# at 620\n" +
+		"#200226 14:46:40 server id 1  end_log_pos 700 CRC32 0x1a2b3c4d \tDelete_rows: table id 108\n" +
+		"### DELETE FROM `test`.`t`\n" +
+		"### WHERE\n" +
+		"###   @1=11 /* INT meta=0 nullable=0 is_null=0 */\n" +
+		"# at 700\n" +
+		"#200226 14:46:40 server id 1  end_log_pos 760 CRC32 0x1a2b3c4d \tDelete_rows: table id 108 flags: STMT_END_F\n" +
+		"### DELETE FROM `test`.`t`\n" +
+		"### WHERE\n" +
+		"###   @1=12 /* INT meta=0 nullable=0 is_null=0 */\n" +
+		"### DELETE FROM `test`.`t`\n" +
+		"### WHERE\n" +
+		"###   @1=13 /* INT meta=0 nullable=0 is_null=0 */\n" +
+		"# at 760\n" +
+		"#200226 14:46:40 server id 1  end_log_pos 791 CRC32 0x1a2b3c4d \tXid = 13\n" +
+		"COMMIT/*!*/;\n" +
+		"# at 791\n" +
+		"#200226 14:46:41 server id 1  end_log_pos 900 CRC32 0x1a2b3c4d \tQuery\tthread_id=7\texec_time=2\terror_code=0\n" +
+		"use `test`/*!*/;\n" +
+		"SET TIMESTAMP=1582728401.250000/*!*/;\n" +
+		"CREATE TABLE u (\n" +
+		"  id int\n" +
+		")\n" +
+		"/*!*/;\n" +
+		"SET @@SESSION.GTID_NEXT= 'AUTOMATIC' /* added by mysqlbinlog */ /*!*/;\n" +
+		"DELIMITER ;\n" +
+		"# End of log file\n"
+
+	var meta outputs.ServerInfo
+
+	src := &inputs.Source{Name: "test"}
+	src.OnServerInfo = func(update func(*outputs.ServerInfo)) { update(&meta) }
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(binlog), src, events))
+	close(events)
+
+	var got []inputs.Query
+	for qry := range events {
+		got = append(got, qry)
+	}
+
+	if !assert.Len(t, got, 3) {
+		return
+	}
+
+	assert.Equal(t, "UPDATE test.t", got[0].FullQuery, "should be equal")
+	assert.Equal(t, "test", got[0].Schema, "should be equal")
+	assert.Equal(t, 2, got[0].RowsAffected, "should be equal")
+	assert.Equal(t, 5, got[0].ConnectionID, "should be equal")
+	assert.Equal(t, "2020-02-26T14:46:35Z", got[0].Time.Format(time.RFC3339), "should be equal")
+	assert.True(t, got[0].NoTiming)
+
+	assert.Equal(t, "DELETE FROM t\nWHERE id > 10", got[1].FullQuery, "should be equal")
+	assert.Equal(t, 3, got[1].RowsAffected, "should be equal")
+	assert.Equal(t, 6, got[1].ConnectionID, "should be equal")
+
+	assert.Equal(t, "CREATE TABLE u (\n  id int\n)", got[2].FullQuery, "should be equal")
+	assert.Equal(t, "test", got[2].Schema, "should be equal")
+	assert.Equal(t, 2.0, got[2].QueryTime, "should be equal")
+	assert.Equal(t, 7, got[2].ConnectionID, "should be equal")
+	assert.Equal(t, "2020-02-26T14:46:41.25Z", got[2].Time.Format(time.RFC3339Nano), "should be equal")
+	assert.False(t, got[2].NoTiming)
+
+	if assert.NotNil(t, meta.Transactions) {
+		assert.Equal(t, outputs.TransactionInfo{Count: 2, CumRows: 5, MaxRows: 3, CumBytes: 232 + 340, MaxBytes: 340}, *meta.Transactions, "should be equal")
+	}
+}
+
+func TestReadBinlogLongLine(t *testing.T) {
+	long := "INSERT INTO t VALUES " + strings.Repeat("(1),", inputs.MaxLineSize/4) + "(1)"
+	binlog := "# at 219\n" +
+		"#200226 14:46:35 server id 1  end_log_pos 291 CRC32 0x1a2b3c4d \tQuery\tthread_id=5\texec_time=1\terror_code=0\n" +
+		long + "/*!*/;\n" +
+		"# at 291\n" +
+		"#200226 14:46:36 server id 1  end_log_pos 391 CRC32 0x1a2b3c4d \tQuery\tthread_id=5\texec_time=0\terror_code=0\n" +
+		"DELETE FROM t/*!*/;\n"
+
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(binlog), src, events))
+	close(events)
+
+	var got []inputs.Query
+	for qry := range events {
+		got = append(got, qry)
+	}
+
+	if !assert.Len(t, got, 2) {
+		return
+	}
+
+	assert.Equal(t, long[:inputs.MaxLineSize], got[0].FullQuery, "should be equal")
+	assert.Equal(t, "DELETE FROM t", got[1].FullQuery, "should be equal")
+	assert.Equal(t, 1, src.TruncatedEntries, "should be equal")
+	assert.Equal(t, 6, src.Lines, "should be equal")
+}
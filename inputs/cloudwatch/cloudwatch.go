@@ -0,0 +1,169 @@
+// Package cloudwatch reads slow logs exported from CloudWatch Logs (RDS, Aurora)
+package cloudwatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs/slowlog"
+)
+
+// cloudwatchEvent holds a CloudWatch Logs event
+// Timestamp is in milliseconds since epoch
+type cloudwatchEvent struct {
+	Timestamp     int64  `json:"timestamp"`
+	Message       string `json:"message"`
+	LogStreamName string `json:"logStreamName"`
+}
+
+// cloudwatchDocument holds events exported from CloudWatch Logs: output of
+// `aws logs filter-log-events` or `get-log-events`, subscription records
+// (Firehose/S3 exports) or single events (JSON lines)
+type cloudwatchDocument struct {
+	cloudwatchEvent
+	Events    []cloudwatchEvent `json:"events"`
+	LogStream string            `json:"logStream"`
+	LogEvents []cloudwatchEvent `json:"logEvents"`
+}
+
+// cloudwatchStream holds a log stream being read as a slow log
+type cloudwatchStream struct {
+	part *inputs.Source
+	w    *io.PipeWriter
+	err  error
+}
+
+// Reader reads CloudWatch Logs JSON exports
+type Reader struct{}
+
+func init() {
+	inputs.Add("cloudwatch", Reader{})
+}
+
+// Followable returns false: exports are JSON documents, which can not be read
+// line by line as they grow
+func (Reader) Followable() bool {
+	return false
+}
+
+// Sniff recognizes CloudWatch Logs JSON exports
+func (Reader) Sniff(head []byte) int {
+	head = bytes.TrimSpace(head)
+	if !bytes.HasPrefix(head, []byte("{")) {
+		return 0
+	}
+
+	switch {
+	case bytes.Contains(head, []byte(`"logEvents":`)), bytes.Contains(head, []byte(`"logStreamName":`)):
+		return inputs.Certain
+	case bytes.Contains(head, []byte(`"events":`)), bytes.Contains(head, []byte(`"message":`)):
+		return 90
+	}
+	return 0
+}
+
+// Read reads slow log events exported from CloudWatch Logs (RDS,
+// Aurora) and sends queries to workers
+// Events from different log streams (instances) are interleaved, so each
+// stream is read as a slow log of its own as events are decoded, using the
+// stream name as source
+// JSON documents read before an error are still reported
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	source := src.Name
+
+	dec := json.NewDecoder(r)
+
+	var wg sync.WaitGroup
+
+	streams := map[string]*cloudwatchStream{}
+	order := []*cloudwatchStream{}
+
+	add := func(name string, ev cloudwatchEvent) {
+		if name == "" {
+			name = source
+		}
+
+		stream, ok := streams[name]
+		if !ok {
+			pr, pw := io.Pipe()
+			stream = &cloudwatchStream{
+				part: &inputs.Source{Name: name, Location: src.Location, Statements: src.Statements},
+				w:    pw,
+			}
+			streams[name] = stream
+			order = append(order, stream)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stream.err = (slowlog.Reader{}).Read(pr, stream.part, events)
+				// Drop events sent after a read error
+				pr.Close()
+			}()
+		}
+
+		var b strings.Builder
+
+		// Entries logged within the same second may lack `# Time`
+		if strings.HasPrefix(ev.Message, "# User@Host") && !strings.Contains(ev.Message, "# Time:") && ev.Timestamp > 0 {
+			ts := time.Unix(0, ev.Timestamp*int64(time.Millisecond)).UTC()
+			b.WriteString("# Time: " + ts.Format(time.RFC3339Nano) + "\n")
+		}
+
+		b.WriteString(ev.Message)
+		if !strings.HasSuffix(ev.Message, "\n") {
+			b.WriteByte('\n')
+		}
+
+		io.WriteString(stream.w, b.String())
+	}
+
+	documents := 0
+	var readErr error
+
+	for {
+		var doc cloudwatchDocument
+
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("after %d JSON documents: %v", documents, err)
+			break
+		}
+		documents++
+
+		for _, ev := range doc.Events {
+			add(ev.LogStreamName, ev)
+		}
+
+		for _, ev := range doc.LogEvents {
+			add(doc.LogStream, ev)
+		}
+
+		if doc.Message != "" {
+			add(doc.LogStreamName, doc.cloudwatchEvent)
+		}
+	}
+
+	for _, stream := range order {
+		stream.w.Close()
+	}
+	wg.Wait()
+
+	for _, stream := range order {
+		if stream.err != nil && readErr == nil {
+			readErr = fmt.Errorf("log stream %s: %v", stream.part.Name, stream.err)
+		}
+		src.Parts = append(src.Parts, stream.part)
+	}
+
+	return readErr
+}
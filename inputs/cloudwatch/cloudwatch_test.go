@@ -0,0 +1,39 @@
+package cloudwatch
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+func TestReadCloudWatch(t *testing.T) {
+	for file, want := range map[string]map[string]int{
+		"testdata/cloudwatch-filter-log-events.json": {"db-prod-1": 2, "db-prod-2": 1},
+		"testdata/cloudwatch-export.json":            {"db-prod-3": 2},
+	} {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatalf("unable to open %s: %v", file, err)
+		}
+
+		src := &inputs.Source{Name: file}
+		events := make(chan inputs.Query, 10)
+
+		assert.Nil(t, Reader{}.Read(f, src, events), file)
+		close(events)
+		f.Close()
+
+		got := map[string]int{}
+		for qry := range events {
+			got[qry.Source]++
+
+			// Event timestamp is used when `# Time` is missing
+			assert.False(t, qry.Time.IsZero(), file)
+		}
+
+		assert.Equal(t, want, got, file)
+		assert.Len(t, src.Parts, len(want), file)
+	}
+}
@@ -0,0 +1,325 @@
+// Package digest reads performance_schema statement digest summaries
+package digest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+// digestColumns maps performance_schema.events_statements_summary_by_digest
+// and sys.statement_analysis column names to the fields we import
+var digestColumns = map[string]string{
+	"digest":                      "digest",
+	"digest_text":                 "text",
+	"query":                       "text",
+	"schema_name":                 "schema",
+	"db":                          "schema",
+	"count_star":                  "count",
+	"exec_count":                  "count",
+	"sum_errors":                  "errors",
+	"err_count":                   "errors",
+	"sum_timer_wait":              "time",
+	"total_latency":               "time",
+	"min_timer_wait":              "min",
+	"max_timer_wait":              "max",
+	"max_latency":                 "max",
+	"quantile_95":                 "p95",
+	"quantile_99":                 "p99",
+	"quantile_999":                "p999",
+	"sum_lock_time":               "lock",
+	"lock_latency":                "lock",
+	"sum_rows_sent":               "rowssent",
+	"rows_sent":                   "rowssent",
+	"sum_rows_examined":           "rowsexamined",
+	"rows_examined":               "rowsexamined",
+	"sum_rows_affected":           "rowsaffected",
+	"rows_affected":               "rowsaffected",
+	"sum_created_tmp_tables":      "tmptables",
+	"tmp_tables":                  "tmptables",
+	"sum_created_tmp_disk_tables": "tmpdisktables",
+	"tmp_disk_tables":             "tmpdisktables",
+	"sum_sort_rows":               "sortrows",
+	"rows_sorted":                 "sortrows",
+	"sum_sort_merge_passes":       "mergepasses",
+	"sort_merge_passes":           "mergepasses",
+	"sum_sort_range":              "sortrange",
+	"sum_sort_scan":               "sortscan",
+	"sum_no_index_used":           "fullscan",
+	"full_scan":                   "fullscan",
+	"sum_select_full_join":        "fulljoin",
+	"first_seen":                  "firstseen",
+	"last_seen":                   "lastseen",
+}
+
+// digestTimeUnits holds sys.format_time() units in picoseconds
+var digestTimeUnits = map[string]float64{
+	"ps":  1,
+	"ns":  1e3,
+	"us":  1e6,
+	"ms":  1e9,
+	"s":   1e12,
+	"m":   60e12,
+	"min": 60e12,
+	"h":   3600e12,
+	"d":   86400e12,
+	"w":   604800e12,
+}
+
+// Reader reads digest summaries dumps
+type Reader struct{}
+
+func init() {
+	inputs.Add("digest", Reader{})
+}
+
+// Sniff recognizes digest summaries dumps from their header
+func (Reader) Sniff(head []byte) int {
+	if i := bytes.IndexByte(head, '\n'); i != -1 {
+		head = head[:i]
+	}
+
+	names, err := parseHeader(string(head))
+	if err != nil {
+		return 0
+	}
+
+	if missing := missingColumn(digestHeaderColumns(names)); missing != "" {
+		return 0
+	}
+
+	return inputs.Certain
+}
+
+// Read reads a digest summary dump (TSV from `mysql -B` or CSV) and sends
+// one query per row to workers
+// Statistics are already aggregated by the server, so queries carry them in
+// their Summary and are merged as is by the aggregator. Rows are not mapped
+// straight to outputs.QueryStats: workers fingerprint DIGEST_TEXT with the
+// rules used for logs, so rows merge with queries of the same fingerprint
+// read from logs in the same run, as well as with each other (e.g. in
+// different schemas), and --group-by applies to them; query times of such
+// log queries are folded in the server distribution when reported
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	br := bufio.NewReader(r)
+
+	header, err := br.ReadString('\n')
+	if err != nil && header == "" {
+		return fmt.Errorf("missing header: %v", err)
+	}
+
+	names, err := parseHeader(header)
+	if err != nil {
+		return fmt.Errorf("invalid header: %v", err)
+	}
+
+	var next func() ([]string, error)
+
+	if strings.Contains(header, "\t") {
+		// mysql -B output: tab separated, special chars are escaped
+		next = func() ([]string, error) {
+			line, err := br.ReadString('\n')
+			if line == "" {
+				return nil, err
+			}
+			fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+			for i := range fields {
+				fields[i] = unescapeBatch(fields[i])
+			}
+			return fields, nil
+		}
+	} else {
+		reader := csv.NewReader(br)
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		next = reader.Read
+	}
+
+	columns := digestHeaderColumns(names)
+	if missing := missingColumn(columns); missing != "" {
+		return fmt.Errorf("missing %s column", missing)
+	}
+
+	// Without FIRST_SEEN & LAST_SEEN, time range is unknown
+	if _, ok := columns["lastseen"]; !ok {
+		log.Warnf("no FIRST_SEEN/LAST_SEEN columns in %s, capture time range is unknown", src.Name)
+	}
+
+	read := 1
+	skipped := 0
+
+	var readErr error
+
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("error after %d rows: %v", read, err)
+			break
+		}
+		read++
+
+		row := digestRow{record: record, columns: columns}
+
+		// The NULL digest row accounts statements not tracked (digest table
+		// full)
+		text := row.text("text")
+		if text == "" {
+			skipped++
+			continue
+		}
+
+		qry := inputs.Query{
+			Source: src.Name,
+			Time:   row.time("lastseen", src.Location),
+			Start:  row.time("firstseen", src.Location),
+			Schema: row.text("schema"),
+			Digest: row.text("digest"),
+			// DIGEST_TEXT quotes identifiers & has no terminator; drop
+			// quotes & terminate it like slow log statements so fingerprints
+			// match those from logs
+			FullQuery:      strings.TrimSuffix(strings.Replace(text, "`", "", -1), ";") + ";",
+			QueryTime:      row.duration("time"),
+			LockTime:       row.duration("lock"),
+			RowsSent:       row.int("rowssent"),
+			RowsExamined:   row.int("rowsexamined"),
+			RowsAffected:   row.int("rowsaffected"),
+			TmpTables:      row.int("tmptables"),
+			TmpDiskTables:  row.int("tmpdisktables"),
+			MergePasses:    row.int("mergepasses"),
+			SortRows:       row.int("sortrows"),
+			SortRangeCount: row.int("sortrange"),
+			SortScanCount:  row.int("sortscan"),
+			Summary: &inputs.Summary{
+				Calls:  row.int("count"),
+				Errors: row.int("errors"),
+				Min:    row.duration("min"),
+				Max:    row.duration("max"),
+				P95:    row.duration("p95"),
+				P99:    row.duration("p99"),
+				P999:   row.duration("p999"),
+			},
+		}
+
+		// Full scans & joins are reported as percentages of calls
+		if _, ok := columns["fullscan"]; ok {
+			qry.Summary.HasQueryPlan = true
+			qry.Summary.FullScans = row.int("fullscan")
+			qry.Summary.FullJoins = row.int("fulljoin")
+		}
+
+		events <- qry
+	}
+
+	src.Lines += read
+	src.SkippedLines += skipped
+
+	return readErr
+}
+
+// parseHeader returns column names of a digest dump header line
+func parseHeader(header string) ([]string, error) {
+	if strings.Contains(header, "\t") {
+		return strings.Split(strings.TrimRight(header, "\r\n"), "\t"), nil
+	}
+	return csv.NewReader(strings.NewReader(header)).Read()
+}
+
+// digestHeaderColumns maps header names to the fields we import
+func digestHeaderColumns(names []string) map[string]int {
+	columns := map[string]int{}
+	for i, name := range names {
+		name = strings.ToLower(strings.Trim(name, "` \""))
+		if field, ok := digestColumns[name]; ok {
+			columns[field] = i
+		}
+	}
+	return columns
+}
+
+// missingColumn returns the first required field missing from columns, or
+// an empty string
+func missingColumn(columns map[string]int) string {
+	for _, required := range []string{"text", "count", "time"} {
+		if _, ok := columns[required]; !ok {
+			return required
+		}
+	}
+	return ""
+}
+
+// digestRow gives typed access to digest dump fields
+type digestRow struct {
+	record  []string
+	columns map[string]int
+}
+
+// text returns field value, or an empty string if column is absent or NULL
+func (d digestRow) text(field string) string {
+	i, ok := d.columns[field]
+	if !ok || i >= len(d.record) || d.record[i] == "NULL" {
+		return ""
+	}
+	return d.record[i]
+}
+
+// int returns field value as an integer
+// sys.statement_analysis full_scan column is "*" when set
+func (d digestRow) int(field string) int {
+	v := d.text(field)
+	if v == "*" {
+		return d.int("count")
+	}
+	n, _ := strconv.ParseFloat(v, 64)
+	return int(n)
+}
+
+// duration returns field value in seconds
+// Raw timers are in picoseconds; sys.format_time() values come with a unit
+// (e.g. "1.23 ms")
+func (d digestRow) duration(field string) float64 {
+	fields := strings.Fields(d.text(field))
+	if len(fields) == 0 {
+		return 0
+	}
+
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+
+	unit := "ps"
+	if len(fields) > 1 {
+		unit = fields[1]
+	}
+
+	return v * digestTimeUnits[unit] / 1e12
+}
+
+// time returns field value as a time
+// Timestamps are in server local time, which is loc
+func (d digestRow) time(field string, loc *time.Location) time.Time {
+	t, err := inputs.ParseTime(d.text(field), loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// unescapeBatch unescapes a field from `mysql --batch` output
+func unescapeBatch(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	return strings.NewReplacer("\\n", "\n", "\\t", "\t", "\\0", "\x00", "\\\\", "\\").Replace(s)
+}
@@ -0,0 +1,94 @@
+package digest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+const pfs = "SCHEMA_NAME\tDIGEST\tDIGEST_TEXT\tCOUNT_STAR\tSUM_TIMER_WAIT\tMIN_TIMER_WAIT\tMAX_TIMER_WAIT\tSUM_ERRORS\tSUM_ROWS_SENT\tQUANTILE_95\tFIRST_SEEN\tLAST_SEEN\n" +
+	"shop\tabc\tSELECT * FROM `users` WHERE `id` = ?\t100\t2000000000000\t1000000000\t90000000000\t2\t100\t50000000000\t2021-03-01 10:00:00.000000\t2021-03-01 11:00:00.000000\n" +
+	"NULL\tNULL\tNULL\t5\t100\t1\t1\t0\t0\t0\t2021-03-01 10:00:00.000000\t2021-03-01 11:00:00.000000\n" +
+	"crm\tabc\tSELECT * FROM `users` WHERE `id` = ?\t10\t1000000000000\t500000000\t100000000000\t0\t10\t60000000000\t2021-03-01 09:00:00.000000\t2021-03-01 10:30:00.000000\n" +
+	"NULL\tdef\tSELECT ?\t1\t100\t100\t100\t0\t1\t100\t2021-03-01 09:00:00.000000\t2021-03-01 10:30:00.000000\n"
+
+const sys = "query,db,full_scan,exec_count,err_count,total_latency,max_latency,lock_latency,rows_sent,rows_examined\n" +
+	"\"SELECT * FROM `t`\",shop,*,4,0,2.00 s,1.50 s,10.00 us,8,40\n"
+
+// read returns queries read from dump
+func read(t *testing.T, dump string) ([]inputs.Query, *inputs.Source) {
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(dump), src, events))
+	close(events)
+
+	var got []inputs.Query
+	for qry := range events {
+		got = append(got, qry)
+	}
+	return got, src
+}
+
+func TestReadDigest(t *testing.T) {
+	got, src := read(t, pfs)
+
+	if !assert.Len(t, got, 3) {
+		return
+	}
+
+	assert.Equal(t, 5, src.Lines, "should be equal")
+	assert.Equal(t, 1, src.SkippedLines, "should be equal")
+
+	assert.Equal(t, "SELECT * FROM users WHERE id = ?;", got[0].FullQuery, "should be equal")
+	assert.Equal(t, "shop", got[0].Schema, "should be equal")
+	assert.Equal(t, "abc", got[0].Digest, "should be equal")
+	assert.Equal(t, 2.0, got[0].QueryTime, "should be equal")
+	assert.Equal(t, 100, got[0].RowsSent, "should be equal")
+	assert.Equal(t, "2021-03-01 10:00:00", got[0].Start.Format("2006-01-02 15:04:05"), "should be equal")
+	assert.Equal(t, "2021-03-01 11:00:00", got[0].Time.Format("2006-01-02 15:04:05"), "should be equal")
+	if assert.NotNil(t, got[0].Summary) {
+		assert.Equal(t, inputs.Summary{Calls: 100, Errors: 2, Min: 0.001, Max: 0.09, P95: 0.05}, *got[0].Summary, "should be equal")
+	}
+
+	assert.Equal(t, "crm", got[1].Schema, "should be equal")
+	assert.Equal(t, 10, got[1].Summary.Calls, "should be equal")
+
+	// NULL schema (no default schema)
+	assert.Equal(t, "", got[2].Schema, "should be equal")
+	assert.Equal(t, "def", got[2].Digest, "should be equal")
+
+	got, _ = read(t, sys)
+
+	if !assert.Len(t, got, 1) {
+		return
+	}
+
+	assert.Equal(t, "SELECT * FROM t;", got[0].FullQuery, "should be equal")
+	assert.Equal(t, 2.0, got[0].QueryTime, "should be equal")
+	assert.Equal(t, 0.00001, got[0].LockTime, "should be equal")
+	assert.Equal(t, 40, got[0].RowsExamined, "should be equal")
+	assert.True(t, got[0].Time.IsZero())
+	if assert.NotNil(t, got[0].Summary) {
+		assert.Equal(t, inputs.Summary{Calls: 4, HasQueryPlan: true, FullScans: 4, Max: 1.5}, *got[0].Summary, "should be equal")
+	}
+
+	events := make(chan inputs.Query, 10)
+	assert.NotNil(t, Reader{}.Read(strings.NewReader("a,b\n1,2\n"), &inputs.Source{Name: "invalid"}, events))
+	assert.Len(t, events, 0)
+}
+
+func TestSniffDigest(t *testing.T) {
+	assert.Equal(t, inputs.Certain, Reader{}.Sniff([]byte(pfs)), "should be equal")
+	assert.Equal(t, inputs.Certain, Reader{}.Sniff([]byte(sys)), "should be equal")
+	assert.Equal(t, 0, Reader{}.Sniff([]byte("a,b\n1,2\n")), "should be equal")
+	assert.Equal(t, 0, Reader{}.Sniff([]byte("# Time: 200226 14:46:34\n")), "should be equal")
+}
+
+func TestUnescapeBatch(t *testing.T) {
+	assert.Equal(t, "SELECT 'a\nb', 'c\\d', '\te\x00'", unescapeBatch(`SELECT 'a\nb', 'c\\d', '\te\0'`), "should be equal")
+	assert.Equal(t, `\n`, unescapeBatch(`\\n`), "should be equal")
+	assert.Equal(t, "no escape", unescapeBatch("no escape"), "should be equal")
+}
@@ -0,0 +1,199 @@
+// Package generallog reads MySQL general query logs
+package generallog
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs/slowlog"
+	"gitlab.com/devopsworks/tools/dw-query-digest/outputs"
+)
+
+// genlogre matches general log command lines
+// MySQL 5.7+: "2018-12-17T15:18:58.744913Z	    3 Query	select 1"
+// MySQL 5.6 : "181217 15:18:58	    3 Query	select 1"
+// or, when time did not change: "		    3 Query	select 1"
+var genlogre = regexp.MustCompile(`^([^\t]*)\t+ *(\d+) ([A-Za-z_ ]+?)(?:\t(.*))?$`)
+
+// genlogsniffre matches general log query & connection lines
+var genlogsniffre = regexp.MustCompile(`(?m)^[^\t\n]*\t+ *\d+ (Query|Connect|Quit|Init DB|Execute|Prepare)\t`)
+
+// genlogConnection holds per-connection state from the general log
+type genlogConnection struct {
+	User   string
+	Client string
+	Schema string
+}
+
+// Reader reads general logs
+type Reader struct{}
+
+func init() {
+	inputs.Add("genlog", Reader{})
+}
+
+// Sniff recognizes MySQL general logs
+func (Reader) Sniff(head []byte) int {
+	if genlogsniffre.Match(head) {
+		return inputs.Certain
+	}
+	return 0
+}
+
+// Read reads a MySQL general query log and sends raw queries to
+// workers
+// The general log does not hold any timing or rows information, so queries
+// are flagged with NoTiming
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	source := src.Name
+
+	// Lines longer than inputs.MaxLineSize are truncated & their query is
+	// counted as truncated
+	scanner, splitter := inputs.NewLineScanner(r)
+
+	connections := map[int]*genlogConnection{}
+
+	var (
+		pending   *inputs.Query
+		truncated bool // pending query has a truncated line
+		curtime   time.Time
+	)
+
+	read := 0
+	skipped := 0
+	truncatedEntries := 0
+
+	// flush sends the pending query, if any
+	flush := func() {
+		if pending == nil {
+			return
+		}
+		if truncated {
+			truncatedEntries++
+		}
+		if strings.TrimSpace(pending.FullQuery) != "" {
+			events <- *pending
+		}
+		pending = nil
+		truncated = false
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		read++
+
+		if splitter.Truncated {
+			log.Debugf("line %d exceeds %d bytes; truncating", read, inputs.MaxLineSize)
+		}
+
+		if slowlog.IsHeaderStart(line) {
+			seg := outputs.ServerSegment{Source: source, StartLine: read}
+			if err := slowlog.ParseVersionLine(line, &seg); err != nil {
+				log.Warnf("unable to parse server header at line %d: %v", read, err)
+			}
+			src.AddSegment(seg)
+			flush()
+			continue
+		}
+
+		if strings.HasPrefix(line, "Tcp port:") || slowlog.IsHeaderColumns(line) {
+			continue
+		}
+
+		matches := genlogre.FindStringSubmatch(line)
+		if matches == nil {
+			// Multiline query continuation, kept verbatim
+			if pending != nil {
+				pending.FullQuery += "\n" + line
+				truncated = truncated || splitter.Truncated
+			} else {
+				skipped++
+			}
+			continue
+		}
+
+		flush()
+
+		if matches[1] != "" {
+			t, err := inputs.ParseTime(matches[1], src.Location)
+			if err != nil {
+				log.Warnf("unable to parse time '%s' at line %d: %v", matches[1], read, err)
+			} else {
+				curtime = t
+			}
+		}
+
+		id, _ := strconv.Atoi(matches[2])
+		command := matches[3]
+		argument := matches[4]
+
+		conn, ok := connections[id]
+		if !ok {
+			conn = &genlogConnection{}
+			connections[id] = conn
+		}
+
+		switch command {
+		case "Connect":
+			// root@localhost on test using Socket
+			parseGeneralConnect(argument, conn)
+		case "Init DB":
+			conn.Schema = argument
+		case "Quit":
+			delete(connections, id)
+		case "Query", "Execute":
+			pending = &inputs.Query{
+				Source:       source,
+				Time:         curtime,
+				User:         conn.User,
+				Client:       conn.Client,
+				ConnectionID: id,
+				Schema:       conn.Schema,
+				FullQuery:    argument,
+				NoTiming:     true,
+			}
+			truncated = splitter.Truncated
+
+			// Track schema changes
+			lower := strings.ToLower(strings.TrimSpace(argument))
+			if strings.HasPrefix(lower, "use ") {
+				conn.Schema = strings.Trim(strings.TrimSpace(argument[4:]), "`;")
+			}
+		}
+	}
+
+	flush()
+
+	src.Lines += read
+	src.SkippedLines += skipped
+	src.TruncatedEntries += truncatedEntries
+	if truncatedEntries > 0 {
+		log.Warnf("truncated %d queries with lines longer than %d bytes in %s", truncatedEntries, inputs.MaxLineSize, source)
+	}
+
+	return scanner.Err()
+}
+
+// parseGeneralConnect parses a general log Connect argument
+// e.g. "root@localhost on test using Socket"
+func parseGeneralConnect(argument string, conn *genlogConnection) {
+	fields := strings.Fields(argument)
+	if len(fields) == 0 {
+		return
+	}
+
+	if at := strings.Index(fields[0], "@"); at != -1 {
+		conn.User = fields[0][:at]
+		conn.Client = fields[0][at+1:]
+	}
+
+	// Schema is optional: "root@localhost on  using TCP/IP"
+	if len(fields) > 2 && fields[1] == "on" && fields[2] != "using" {
+		conn.Schema = fields[2]
+	}
+}
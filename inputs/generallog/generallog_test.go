@@ -0,0 +1,55 @@
+package generallog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+func TestReadGeneralLog(t *testing.T) {
+	genlog := "/usr/sbin/mysqld, Version: 5.6.40-log (MySQL Community Server (GPL)). started with:\n" +
+		"Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock\n" +
+		"Time                 Id Command    Argument\n" +
+		"190603  3:14:02\t    3 Connect\troot@localhost on test\n" +
+		"\t\t    3 Query\tselect * from t where a = 1\n" +
+		"\t\t    4 Connect\tapp@10.0.0.1 on  using TCP/IP\n" +
+		"190603  3:14:03\t    4 Init DB\tshop\n" +
+		"\t\t    4 Query\tSELECT *\n" +
+		"FROM products\n" +
+		"\t\t    3 Quit\t\n"
+
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(genlog), src, events))
+	close(events)
+
+	var got []inputs.Query
+	for qry := range events {
+		got = append(got, qry)
+	}
+
+	if !assert.Len(t, got, 2) {
+		return
+	}
+
+	assert.Equal(t, "select * from t where a = 1", got[0].FullQuery, "should be equal")
+	assert.Equal(t, "test", got[0].Schema, "should be equal")
+	assert.Equal(t, "root", got[0].User, "should be equal")
+	assert.Equal(t, 3, got[0].ConnectionID, "should be equal")
+	assert.Equal(t, "2019-06-03 03:14:02", got[0].Time.Format("2006-01-02 15:04:05"), "should be equal")
+	assert.True(t, got[0].NoTiming)
+
+	assert.Equal(t, "SELECT *\nFROM products", got[1].FullQuery, "should be equal")
+	assert.Equal(t, "shop", got[1].Schema, "should be equal")
+	assert.Equal(t, "app", got[1].User, "should be equal")
+	assert.Equal(t, "10.0.0.1", got[1].Client, "should be equal")
+	assert.Equal(t, "2019-06-03 03:14:03", got[1].Time.Format("2006-01-02 15:04:05"), "should be equal")
+
+	if assert.Len(t, src.Segments, 1) {
+		assert.Equal(t, "5.6.40-log", src.Segments[0].Version, "should be equal")
+	}
+	assert.Equal(t, 10, src.Lines, "should be equal")
+}
@@ -0,0 +1,297 @@
+package inputs
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"time"
+
+	"gitlab.com/devopsworks/tools/dw-query-digest/outputs"
+)
+
+// SniffSize is the maximum amount of data used to detect input format
+const SniffSize = 8 * 1024
+
+// Certain is the score of a reader recognizing its format for sure
+const Certain = 100
+
+// Query holds a single query with metrics, as read from an input
+// FingerPrint & Hash can be left empty, queries are then fingerprinted before
+// being aggregated
+type Query struct {
+	Source       string
+	Time         time.Time
+	User         string
+	AltUser      string
+	Client       string
+	ConnectionID int
+	Schema       string
+	LastErrno    int
+	QCHit        bool
+	Killed       int
+	QueryTime    float64
+	LockTime     float64
+	RowsSent     int
+	RowsExamined int
+	RowsAffected int
+	BytesSent    int
+	FullQuery    string
+	FingerPrint  string
+	Hash         [32]byte
+	NoTiming     bool // no time based metrics (e.g. general log)
+	Admin        bool // administrator command (e.g. Quit, Binlog Dump)
+
+	// Normalize, when set, fingerprints FullQuery instead of MySQL rules
+	// (e.g. PostgreSQL syntax)
+	Normalize func(query string) string
+
+	// Summary holds statistics aggregated by the server (e.g. digest
+	// summaries); the query then stands for Summary.Calls executions and
+	// cumulated metrics are held by query fields (QueryTime, RowsSent, ...)
+	Summary *Summary
+
+	// Percona extended attributes (log_slow_verbosity=full)
+	HasQueryPlan        bool
+	HasInnoDB           bool
+	TmpTables           int
+	TmpDiskTables       int
+	TmpTableSizes       int
+	FullScan            bool
+	FullJoin            bool
+	TmpTable            bool
+	TmpTableOnDisk      bool
+	Filesort            bool
+	FilesortOnDisk      bool
+	MergePasses         int
+	InnoDBIOReadOps     int
+	InnoDBIOReadBytes   int
+	InnoDBIOReadWait    float64
+	InnoDBRecLockWait   float64
+	InnoDBQueueWait     float64
+	InnoDBPagesDistinct int
+
+	// MySQL 8.0 extra attributes (log_slow_extra=ON)
+	HasSlowExtra   bool
+	Start          time.Time
+	BytesReceived  int
+	ReadFirst      int
+	ReadLast       int
+	ReadKey        int
+	ReadNext       int
+	ReadPrev       int
+	ReadRnd        int
+	ReadRndNext    int
+	SortRangeCount int
+	SortRows       int
+	SortScanCount  int
+
+	// MariaDB execution plan (log_slow_verbosity=explain)
+	ExplainColumns []string
+	ExplainRows    [][]string
+
+	// Stored routine running the query (Percona log_slow_sp_statements)
+	Routine string
+
+	// Proxy attributes (ProxySQL) & server computed digest (ProxySQL, TiDB)
+	Digest    string
+	Hostgroup string
+	Backend   string
+
+	// TiDB attributes
+	HasTiDB     bool
+	ParseTime   float64
+	CompileTime float64
+	CopTime     float64
+	ProcessTime float64
+	WaitTime    float64
+	BackoffTime float64
+	ProcessKeys int
+	TotalKeys   int
+	CopTasks    int
+	WriteKeys   int
+	MemMax      int
+	DiskMax     int
+}
+
+// Summary holds statistics of executions aggregated by the server
+type Summary struct {
+	Calls  int
+	Errors int
+
+	// Query plan counters, when known
+	HasQueryPlan bool
+	FullScans    int
+	FullJoins    int
+
+	// Query time distribution, in seconds
+	Min  float64
+	Max  float64
+	P95  float64
+	P99  float64
+	P999 float64
+}
+
+// Multi-statement entries handling (--multi-statements)
+const (
+	// StatementsCompound reports all statements as a single query
+	StatementsCompound = "compound"
+	// StatementsSplit reports each statement, splitting the entry time evenly
+	StatementsSplit = "split"
+	// StatementsShared reports each statement with the whole entry time
+	StatementsShared = "shared"
+)
+
+// StatementsModes holds valid multi-statement entries handling modes
+var StatementsModes = []string{StatementsCompound, StatementsSplit, StatementsShared}
+
+// Source holds an input being read
+// Readers fill lines counts & record server headers as they go
+type Source struct {
+	Name             string
+	Lines            int
+	SkippedLines     int
+	TruncatedEntries int
+	Segments         []outputs.ServerSegment
+
+	// Location is the timezone of timestamps without one (UTC when nil)
+	Location *time.Location
+
+	// Statements tells how entries holding several statements are reported
+	// (StatementsCompound when empty)
+	Statements string
+
+	// Parts holds sources found inside this one (e.g. CloudWatch log
+	// streams); when set, they are reported instead of the source itself
+	Parts []*Source
+
+	// OnSegment, when set, is called for each server header found
+	OnSegment func(seg outputs.ServerSegment)
+
+	// OnServerInfo, when set, applies server wide information found (e.g.
+	// binary logs transactions) to the report
+	OnServerInfo func(update func(meta *outputs.ServerInfo))
+}
+
+// AddSegment records a server header found in source
+func (s *Source) AddSegment(seg outputs.ServerSegment) {
+	s.Segments = append(s.Segments, seg)
+	if s.OnSegment != nil {
+		s.OnSegment(seg)
+	}
+}
+
+// UpdateServerInfo records server wide information found in source
+// update is called with the report server information, when reported
+func (s *Source) UpdateServerInfo(update func(meta *outputs.ServerInfo)) {
+	if s.OnServerInfo != nil {
+		s.OnServerInfo(update)
+	}
+}
+
+// Reader reads an input format
+type Reader interface {
+	// Sniff returns how likely head, the beginning of an input, is in the
+	// reader format, from 0 (not at all) to Certain
+	Sniff(head []byte) int
+
+	// Read reads queries from r & sends them to events
+	Read(r io.Reader, src *Source, events chan<- Query) error
+}
+
+// Configurable is implemented by readers whose results depend on options
+// (e.g. command line flags they register)
+type Configurable interface {
+	// Options returns option names & values results depend on
+	Options() map[string]string
+}
+
+// Followable is implemented by readers telling if their inputs can be
+// followed as they grow (--follow); other readers' inputs can
+type Followable interface {
+	Followable() bool
+}
+
+// Inputs is a map containing name to reader mapping
+var Inputs = map[string]Reader{}
+
+// Add lets each input to add themselves in Inputs
+func Add(name string, reader Reader) {
+	Inputs[name] = reader
+}
+
+// Names returns registered input names, sorted
+func Names() []string {
+	names := make([]string, 0, len(Inputs))
+	for name := range Inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options returns options results of registered readers depend on
+// All readers are considered as any of them can be detected
+func Options() map[string]string {
+	options := map[string]string{}
+
+	for _, reader := range Inputs {
+		if c, ok := reader.(Configurable); ok {
+			for name, value := range c.Options() {
+				options[name] = value
+			}
+		}
+	}
+
+	return options
+}
+
+// CanFollow returns true if inputs in format name can be followed
+func CanFollow(name string) bool {
+	if f, ok := Inputs[name].(Followable); ok {
+		return f.Followable()
+	}
+	return true
+}
+
+// Detect returns the most likely input format of head & its score
+// Ties are broken by name so detection is deterministic
+func Detect(head []byte) (string, int) {
+	best, bestScore := "", 0
+
+	for _, name := range Names() {
+		if score := Inputs[name].Sniff(head); score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+
+	return best, bestScore
+}
+
+// Sniff detects input format from the beginning of br, without consuming it
+// It returns an empty string if format is unknown
+// Data is only waited for until format is certain, so it is safe to use on
+// followed files as long as their format is recognizable
+func Sniff(br *bufio.Reader) string {
+	format, score := "", 0
+
+	_, err := br.Peek(1)
+	for {
+		head, _ := br.Peek(br.Buffered())
+		if len(head) > SniffSize {
+			head = head[:SniffSize]
+		}
+
+		format, score = Detect(head)
+		if score >= Certain || err != nil || len(head) >= SniffSize {
+			break
+		}
+
+		_, err = br.Peek(br.Buffered() + 1)
+	}
+
+	if score == 0 {
+		return ""
+	}
+
+	return format
+}
@@ -0,0 +1,75 @@
+package inputs
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// MaxLineSize is the maximum size of a log line; longer lines (e.g. huge
+// multi-row INSERTs) are truncated
+const MaxLineSize = 16 * 1024 * 1024
+
+// LineSplitter splits lines like bufio.ScanLines, but truncates lines longer
+// than Max instead of failing
+type LineSplitter struct {
+	Max       int
+	Truncated bool // last line was truncated
+	skipping  bool // dropping the end of a truncated line
+}
+
+// Split is a bufio.SplitFunc
+func (s *LineSplitter) Split(data []byte, atEOF bool) (int, []byte, error) {
+	if s.skipping {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			s.skipping = false
+			return i + 1, nil, nil
+		}
+		return len(data), nil, nil
+	}
+
+	s.Truncated = false
+
+	if len(data) >= s.Max && bytes.IndexByte(data[:s.Max], '\n') < 0 {
+		s.skipping = true
+		s.Truncated = true
+		return s.Max, data[:s.Max], nil
+	}
+
+	return bufio.ScanLines(data, atEOF)
+}
+
+// NewLineScanner returns a scanner reading lines from r, truncating those
+// longer than MaxLineSize; the splitter tells if the last line was truncated
+func NewLineScanner(r io.Reader) (*bufio.Scanner, *LineSplitter) {
+	splitter := &LineSplitter{Max: MaxLineSize}
+
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 0, 64*1024)
+	scanner.Buffer(buf, MaxLineSize+1)
+	scanner.Split(splitter.Split)
+
+	return scanner, splitter
+}
+
+// PeekLine returns the first non empty line in br without consuming it
+// It only waits for more data until a line is complete, so it is safe to use
+// on followed files
+func PeekLine(br *bufio.Reader) []byte {
+	data, err := br.Peek(1)
+	for err == nil {
+		data, _ = br.Peek(br.Buffered())
+
+		trimmed := bytes.TrimLeft(data, "\r\n")
+		if idx := bytes.IndexByte(trimmed, '\n'); idx != -1 {
+			return trimmed[:idx]
+		}
+		if br.Buffered() == br.Size() {
+			return trimmed
+		}
+
+		_, err = br.Peek(br.Buffered() + 1)
+	}
+
+	return bytes.TrimLeft(data, "\r\n")
+}
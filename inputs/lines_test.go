@@ -0,0 +1,28 @@
+package inputs
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineSplitter(t *testing.T) {
+	splitter := &LineSplitter{Max: 8}
+
+	scanner := bufio.NewScanner(strings.NewReader("short\n0123456789abcdef\nnext\n"))
+	scanner.Buffer(make([]byte, 0, 4), splitter.Max+1)
+	scanner.Split(splitter.Split)
+
+	var got []string
+	var truncated []bool
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+		truncated = append(truncated, splitter.Truncated)
+	}
+
+	assert.Nil(t, scanner.Err())
+	assert.Equal(t, []string{"short", "01234567", "next"}, got, "should be equal")
+	assert.Equal(t, []bool{false, true, false}, truncated, "should be equal")
+}
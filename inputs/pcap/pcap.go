@@ -0,0 +1,625 @@
+// Package pcap reads MySQL traffic from pcap & pcapng network captures
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+	"gitlab.com/devopsworks/tools/dw-query-digest/outputs"
+)
+
+// MySQL protocol commands we care about
+const (
+	comQuit        = 0x01
+	comInitDB      = 0x02
+	comQuery       = 0x03
+	comStmtPrepare = 0x16
+	comStmtExecute = 0x17
+	comStmtClose   = 0x19
+)
+
+// MySQL capability flags used when decoding the handshake response
+const (
+	clientConnectWithDB        = 0x00000008
+	clientProtocol41           = 0x00000200
+	clientSSL                  = 0x00000800
+	clientSecureConnection     = 0x00008000
+	clientPluginAuthLenencData = 0x00200000
+)
+
+const (
+	serverMoreResultsExists        = 0x0008
+	mysqlMaxPacketLength           = 0xffffff
+	maxPendingSegments             = 256
+	pcapngMagic             uint32 = 0x0a0d0d0a
+)
+
+// Response decoding stages
+const (
+	stageFirst = iota
+	stageColumns
+	stageColumnsEOF
+	stageRows
+	stageDone
+)
+
+var versionshortre = regexp.MustCompile(`^[0-9\.]+`)
+
+// pcapMagics holds pcap (micro & nanosecond resolution) & pcapng magic numbers
+var pcapMagics = []uint32{0xa1b2c3d4, 0xa1b23c4d, pcapngMagic}
+
+// serverPort is the MySQL server port in captures (--mysql-port)
+var serverPort int
+
+// Reader reads network captures
+type Reader struct{}
+
+func init() {
+	inputs.Add("pcap", Reader{})
+
+	flag.IntVar(&serverPort, "mysql-port", 3306, "MySQL server port in network captures (pcap input format)")
+}
+
+// Options returns the MySQL server port (--mysql-port)
+func (Reader) Options() map[string]string {
+	return map[string]string{"mysql-port": strconv.Itoa(serverPort)}
+}
+
+// Followable returns false: captures are binary and can not be read line by
+// line as they grow
+func (Reader) Followable() bool {
+	return false
+}
+
+// Sniff recognizes pcap & pcapng captures from their magic number, in either
+// byte order
+func (Reader) Sniff(head []byte) int {
+	if len(head) < 4 {
+		return 0
+	}
+	for _, magic := range pcapMagics {
+		if binary.LittleEndian.Uint32(head) == magic || binary.BigEndian.Uint32(head) == magic {
+			return inputs.Certain
+		}
+	}
+	return 0
+}
+
+// segment holds a chunk of reassembled TCP payload
+type segment struct {
+	data []byte
+	ts   time.Time
+}
+
+// tcpStream reassembles one direction of a TCP connection
+type tcpStream struct {
+	started bool
+	next    uint32
+	pending map[uint32]segment
+	buf     []byte
+}
+
+// mysqlCommand holds a client command and its response metrics
+type mysqlCommand struct {
+	kind     byte
+	text     string
+	start    time.Time
+	end      time.Time
+	stage    int
+	columns  int
+	rows     int
+	affected int
+	bytes    int
+	errno    int
+}
+
+// mysqlConn holds the state of a captured MySQL connection
+type mysqlConn struct {
+	client        string
+	id            int
+	user          string
+	schema        string
+	greeted       bool
+	authenticated bool
+	encrypted     bool
+	stmts         map[uint32]string
+	cmd           *mysqlCommand
+	toServer      tcpStream
+	toClient      tcpStream
+}
+
+// Read reads a pcap or pcapng capture of MySQL traffic, reassembles TCP
+// streams and decodes MySQL protocol to send raw queries to workers
+// Client & server are told apart using the server port (--mysql-port)
+// Packets read before an error are still reported
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	source := src.Name
+
+	br := bufio.NewReader(r)
+
+	var (
+		next     func() ([]byte, gopacket.CaptureInfo, error)
+		linktype func(gopacket.CaptureInfo) layers.LinkType
+	)
+
+	magic, _ := br.Peek(4)
+	if len(magic) == 4 && binary.LittleEndian.Uint32(magic) == pcapngMagic {
+		ng, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return fmt.Errorf("unable to read pcapng capture: %v", err)
+		}
+		next = ng.ReadPacketData
+		linktype = func(ci gopacket.CaptureInfo) layers.LinkType {
+			if intf, err := ng.Interface(ci.InterfaceIndex); err == nil {
+				return intf.LinkType
+			}
+			return ng.LinkType()
+		}
+	} else {
+		pr, err := pcapgo.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("unable to read pcap capture: %v", err)
+		}
+		next = pr.ReadPacketData
+		linktype = func(gopacket.CaptureInfo) layers.LinkType {
+			return pr.LinkType()
+		}
+	}
+
+	conns := map[string]*mysqlConn{}
+	port := layers.TCPPort(serverPort)
+	read := 0
+
+	var readErr error
+
+	for {
+		data, ci, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("after %d packets: %v", read, err)
+			break
+		}
+		read++
+
+		packet := gopacket.NewPacket(data, linktype(ci), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok || packet.NetworkLayer() == nil {
+			continue
+		}
+
+		netflow := packet.NetworkLayer().NetworkFlow()
+
+		var (
+			client   string
+			toServer bool
+		)
+
+		switch port {
+		case tcp.DstPort:
+			client = fmt.Sprintf("%s:%d", netflow.Src(), tcp.SrcPort)
+			toServer = true
+		case tcp.SrcPort:
+			client = fmt.Sprintf("%s:%d", netflow.Dst(), tcp.DstPort)
+		default:
+			continue
+		}
+
+		conn, ok := conns[client]
+		if !ok {
+			conn = &mysqlConn{client: client, stmts: map[uint32]string{}}
+			conns[client] = conn
+		}
+
+		stream := &conn.toClient
+		if toServer {
+			stream = &conn.toServer
+		}
+
+		for _, seg := range stream.add(tcp.Seq, tcp.SYN, tcp.Payload, ci.Timestamp) {
+			stream.buf = append(stream.buf, seg.data...)
+			stream.packets(func(seq byte, payload []byte) {
+				if toServer {
+					conn.clientPacket(seq, payload, seg.ts, source, events)
+				} else {
+					conn.serverPacket(seq, payload, seg.ts, src, read)
+				}
+			})
+		}
+
+		if tcp.FIN || tcp.RST {
+			conn.flush(source, events)
+			delete(conns, client)
+		}
+	}
+
+	// Capture ended; ship what we have
+	for _, conn := range conns {
+		conn.flush(source, events)
+	}
+
+	src.Lines += read
+
+	return readErr
+}
+
+// add adds a TCP segment to the stream and returns in-order data segments
+// Retransmitted data is dropped and out of order segments are kept until
+// missing data shows up; if it never does, the gap is skipped
+func (s *tcpStream) add(seq uint32, syn bool, data []byte, ts time.Time) []segment {
+	if syn {
+		s.started = true
+		s.next = seq + 1
+		return nil
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if !s.started {
+		s.started = true
+		s.next = seq
+	}
+
+	if s.pending == nil {
+		s.pending = map[uint32]segment{}
+	}
+
+	s.pending[seq] = segment{data: append([]byte(nil), data...), ts: ts}
+
+	// Give up on missing data
+	if len(s.pending) > maxPendingSegments {
+		first := true
+		for pseq := range s.pending {
+			if first || int32(pseq-s.next) < 0 {
+				s.next = pseq
+				first = false
+			}
+		}
+		// MySQL framing is lost too
+		s.buf = s.buf[:0]
+		log.Debugf("skipping missing TCP data, resuming at seq %d", s.next)
+	}
+
+	var out []segment
+
+	for found := true; found; {
+		found = false
+		for pseq, p := range s.pending {
+			diff := int32(pseq - s.next)
+			if diff > 0 {
+				continue
+			}
+
+			delete(s.pending, pseq)
+			found = true
+
+			// Keep unseen part of retransmitted data only
+			if int(-diff) < len(p.data) {
+				out = append(out, segment{data: p.data[-diff:], ts: p.ts})
+				s.next += uint32(len(p.data) + int(diff))
+			}
+		}
+	}
+
+	return out
+}
+
+// packets extracts complete MySQL packets from stream buffer and calls fn
+// for each
+func (s *tcpStream) packets(fn func(seq byte, payload []byte)) {
+	buf := s.buf
+
+	for len(buf) >= 4 {
+		length := int(buf[0]) | int(buf[1])<<8 | int(buf[2])<<16
+		if len(buf) < 4+length {
+			break
+		}
+
+		fn(buf[3], buf[4:4+length])
+		buf = buf[4+length:]
+	}
+
+	n := copy(s.buf, buf)
+	s.buf = s.buf[:n]
+}
+
+// clientPacket handles a packet sent by the client
+// Commands always start with sequence 0
+func (c *mysqlConn) clientPacket(seq byte, payload []byte, ts time.Time, source string, queries chan<- inputs.Query) {
+	if c.encrypted || len(payload) == 0 {
+		return
+	}
+
+	if seq == 1 && c.greeted && !c.authenticated {
+		c.authenticated = true
+		c.handshakeResponse(payload)
+		return
+	}
+
+	if seq != 0 {
+		return
+	}
+
+	c.flush(source, queries)
+
+	switch payload[0] {
+	case comQuery, comStmtPrepare:
+		c.cmd = &mysqlCommand{kind: payload[0], text: string(payload[1:]), start: ts, end: ts}
+
+		lower := strings.ToLower(strings.TrimSpace(c.cmd.text))
+		if payload[0] == comQuery && strings.HasPrefix(lower, "use ") {
+			c.schema = strings.Trim(strings.TrimSpace(c.cmd.text[4:]), "`;")
+		}
+
+	case comStmtExecute:
+		if len(payload) < 5 {
+			return
+		}
+		id := binary.LittleEndian.Uint32(payload[1:5])
+		text, ok := c.stmts[id]
+		if !ok {
+			// Statement was prepared before capture started
+			text = "EXECUTE unknown_statement"
+		}
+		c.cmd = &mysqlCommand{kind: comStmtExecute, text: text, start: ts, end: ts}
+
+	case comStmtClose:
+		if len(payload) >= 5 {
+			delete(c.stmts, binary.LittleEndian.Uint32(payload[1:5]))
+		}
+
+	case comInitDB:
+		c.schema = string(payload[1:])
+	}
+}
+
+// serverPacket handles a packet sent by the server
+func (c *mysqlConn) serverPacket(seq byte, payload []byte, ts time.Time, src *inputs.Source, packetnum int) {
+	if c.encrypted || len(payload) == 0 {
+		return
+	}
+
+	// Server greeting is the only server packet with sequence 0
+	if seq == 0 && payload[0] == 0x0a && !c.greeted {
+		c.greeted = true
+		c.greeting(payload, src, packetnum)
+		return
+	}
+
+	cmd := c.cmd
+	if cmd == nil {
+		return
+	}
+
+	cmd.bytes += len(payload) + 4
+	cmd.end = ts
+
+	switch cmd.stage {
+	case stageFirst:
+		switch payload[0] {
+		case 0x00:
+			if cmd.kind == comStmtPrepare {
+				if len(payload) >= 5 {
+					c.stmts[binary.LittleEndian.Uint32(payload[1:5])] = cmd.text
+				}
+				cmd.stage = stageDone
+				return
+			}
+			cmd.okPacket(payload)
+		case 0xff:
+			cmd.errPacket(payload)
+		case 0xfb:
+			// LOAD DATA LOCAL INFILE request
+			cmd.stage = stageDone
+		default:
+			cmd.columns, _ = lenencInt(payload)
+			cmd.stage = stageColumns
+		}
+
+	case stageColumns:
+		cmd.columns--
+		if cmd.columns <= 0 {
+			cmd.stage = stageColumnsEOF
+		}
+
+	case stageColumnsEOF:
+		cmd.stage = stageRows
+		// EOF after column definitions is absent with CLIENT_DEPRECATE_EOF
+		if payload[0] == 0xfe && len(payload) <= 5 {
+			return
+		}
+		cmd.rowPacket(payload)
+
+	case stageRows:
+		cmd.rowPacket(payload)
+	}
+}
+
+// okPacket handles an OK packet (affected rows & more results flag)
+func (cmd *mysqlCommand) okPacket(payload []byte) {
+	affected, n := lenencInt(payload[1:])
+	cmd.affected += affected
+
+	_, m := lenencInt(payload[1+n:])
+	cmd.stage = stageDone
+	if pos := 1 + n + m; len(payload) >= pos+2 && binary.LittleEndian.Uint16(payload[pos:])&serverMoreResultsExists != 0 {
+		cmd.stage = stageFirst
+	}
+}
+
+// errPacket handles an ERR packet
+func (cmd *mysqlCommand) errPacket(payload []byte) {
+	if len(payload) >= 3 {
+		cmd.errno = int(binary.LittleEndian.Uint16(payload[1:3]))
+	}
+	cmd.stage = stageDone
+}
+
+// rowPacket handles a packet in a resultset; rows end with an EOF packet (or
+// an OK packet with a 0xfe header with CLIENT_DEPRECATE_EOF)
+func (cmd *mysqlCommand) rowPacket(payload []byte) {
+	switch {
+	case payload[0] == 0xfe && len(payload) < mysqlMaxPacketLength:
+		cmd.stage = stageDone
+		if len(payload) == 5 {
+			if binary.LittleEndian.Uint16(payload[3:5])&serverMoreResultsExists != 0 {
+				cmd.stage = stageFirst
+			}
+			return
+		}
+		cmd.okPacket(payload)
+	case payload[0] == 0xff:
+		cmd.errPacket(payload)
+	default:
+		cmd.rows++
+	}
+}
+
+// greeting decodes server greeting (connection id & server version)
+func (c *mysqlConn) greeting(payload []byte, src *inputs.Source, packetnum int) {
+	version, rest := cstring(payload[1:])
+	if len(rest) >= 4 {
+		c.id = int(binary.LittleEndian.Uint32(rest[0:4]))
+	}
+
+	// Captures do not have headers; record the first server version we see
+	if len(src.Segments) == 0 && version != "" {
+		seg := outputs.ServerSegment{
+			Source:             src.Name,
+			StartLine:          packetnum,
+			VersionShort:       versionshortre.FindString(version),
+			Version:            version,
+			VersionDescription: "from server greeting",
+		}
+		src.AddSegment(seg)
+	}
+}
+
+// handshakeResponse decodes client handshake response (user & schema)
+func (c *mysqlConn) handshakeResponse(payload []byte) {
+	if len(payload) < 32 {
+		return
+	}
+
+	caps := binary.LittleEndian.Uint32(payload[0:4])
+	if caps&clientProtocol41 == 0 {
+		return
+	}
+
+	// SSL request: the rest of the connection is encrypted
+	if caps&clientSSL != 0 && len(payload) == 32 {
+		log.Debugf("connection from %s is encrypted, ignoring", c.client)
+		c.encrypted = true
+		return
+	}
+
+	user, rest := cstring(payload[32:])
+	c.user = user
+
+	switch {
+	case caps&clientPluginAuthLenencData != 0:
+		n, m := lenencInt(rest)
+		if m+n > len(rest) {
+			return
+		}
+		rest = rest[m+n:]
+	case caps&clientSecureConnection != 0:
+		if len(rest) == 0 || 1+int(rest[0]) > len(rest) {
+			return
+		}
+		rest = rest[1+int(rest[0]):]
+	default:
+		_, rest = cstring(rest)
+	}
+
+	if caps&clientConnectWithDB != 0 {
+		c.schema, _ = cstring(rest)
+	}
+}
+
+// flush sends current command to workers
+func (c *mysqlConn) flush(source string, queries chan<- inputs.Query) {
+	cmd := c.cmd
+	c.cmd = nil
+
+	if cmd == nil || (cmd.kind != comQuery && cmd.kind != comStmtExecute) {
+		return
+	}
+
+	qry := inputs.Query{
+		Source:       source,
+		Time:         cmd.end,
+		Start:        cmd.start,
+		User:         c.user,
+		Client:       c.client[:strings.LastIndex(c.client, ":")],
+		ConnectionID: c.id,
+		Schema:       c.schema,
+		LastErrno:    cmd.errno,
+		QueryTime:    cmd.end.Sub(cmd.start).Seconds(),
+		RowsSent:     cmd.rows,
+		RowsAffected: cmd.affected,
+		BytesSent:    cmd.bytes,
+		FullQuery:    cmd.text,
+	}
+
+	if strings.TrimSpace(qry.FullQuery) == "" {
+		return
+	}
+	queries <- qry
+}
+
+// lenencInt decodes a MySQL length encoded integer and returns its value and
+// encoded size
+func lenencInt(b []byte) (int, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+
+	size := 1
+	switch b[0] {
+	case 0xfc:
+		size = 3
+	case 0xfd:
+		size = 4
+	case 0xfe:
+		size = 9
+	default:
+		return int(b[0]), 1
+	}
+
+	if len(b) < size {
+		return 0, len(b)
+	}
+
+	v := 0
+	for i := size - 1; i >= 1; i-- {
+		v = v<<8 | int(b[i])
+	}
+	return v, size
+}
+
+// cstring returns the NUL terminated string at the beginning of b and the
+// remaining bytes
+func cstring(b []byte) (string, []byte) {
+	idx := bytes.IndexByte(b, 0)
+	if idx == -1 {
+		return string(b), nil
+	}
+	return string(b[:idx]), b[idx+1:]
+}
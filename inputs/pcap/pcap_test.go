@@ -0,0 +1,58 @@
+package pcap
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+func TestReadPcap(t *testing.T) {
+	for _, file := range []string{"testdata/mysql.pcap", "testdata/mysql.pcapng"} {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatalf("unable to open %s: %v", file, err)
+		}
+
+		src := &inputs.Source{Name: "test"}
+		events := make(chan inputs.Query, 10)
+
+		assert.Nil(t, Reader{}.Read(f, src, events), file)
+		close(events)
+		f.Close()
+
+		var got []inputs.Query
+		for qry := range events {
+			got = append(got, qry)
+		}
+
+		if !assert.Len(t, got, 5, file) {
+			continue
+		}
+
+		// Resultset split in out of order & retransmitted segments
+		assert.Equal(t, "SELECT id, name FROM users WHERE id > 10", got[0].FullQuery, file)
+		assert.Equal(t, 2, got[0].RowsSent, file)
+		assert.Equal(t, "app", got[0].User, file)
+		assert.Equal(t, "shop", got[0].Schema, file)
+		assert.Equal(t, "10.0.0.2", got[0].Client, file)
+		assert.Equal(t, 42, got[0].ConnectionID, file)
+		assert.Equal(t, 0.001, got[0].QueryTime, file)
+
+		assert.Equal(t, "UPDATE users SET name = 'carol' WHERE id = 12", got[1].FullQuery, file)
+		assert.Equal(t, 1, got[1].RowsAffected, file)
+
+		assert.Equal(t, "SELECT * FROM nope", got[2].FullQuery, file)
+		assert.Equal(t, 1146, got[2].LastErrno, file)
+
+		// Prepared statements
+		assert.Equal(t, "SELECT name FROM users WHERE id = ?", got[3].FullQuery, file)
+		assert.Equal(t, 1, got[3].RowsSent, file)
+		assert.Equal(t, got[3].FullQuery, got[4].FullQuery, file)
+
+		if assert.Len(t, src.Segments, 1, file) {
+			assert.Equal(t, "5.7.33-log", src.Segments[0].Version, file)
+		}
+	}
+}
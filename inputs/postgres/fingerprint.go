@@ -0,0 +1,244 @@
+package postgres
+
+import (
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+// replacement is a regexp replacement applied to queries
+type replacement struct {
+	Rexp *regexp.Regexp
+	Repl string
+}
+
+// pgregexeps holds regexps applied to PostgreSQL queries once literals,
+// placeholders & casts have been replaced by normalizePostgres
+var pgregexeps []replacement
+
+func init() {
+	pgregexeps = []replacement{
+		// Collapse whitespace first so following patterns stay simple
+		{regexp.MustCompile(`\s+`), " "},
+		{regexp.MustCompile(`\s*([!<>=]{1,2})\s*\?`), " $1 ?"},
+		{regexp.MustCompile(`^(insert .*?) values.*`), "$1 values (?)"},
+		{regexp.MustCompile(`in ?\( ?\?(?: ?, ?\?)* ?\)`), "in (?)"},
+		{regexp.MustCompile(`any ?\( ?array ?\[[^\]]*\] ?\)`), "any (?)"},
+		{regexp.MustCompile(`^ | $`), ""},
+	}
+}
+
+// fingerprint normalizes PostgreSQL queries; it is the Normalize hook of
+// queries sent by readers
+// Literals (including dollar-quoted strings), numbers & $n placeholders are
+// replaced with '?', casts are removed and double-quoted identifiers are kept
+// verbatim
+func fingerprint(query string) string {
+	log.Debugf("fingerprint raw query: %s", query)
+
+	fp := inputs.NormalizeStatements(normalizePostgres(query), func(stmt string) string {
+		for _, r := range pgregexeps {
+			stmt = r.Rexp.ReplaceAllString(stmt, r.Repl)
+		}
+		return stmt
+	})
+
+	log.Debugf("fingerprint normalized query to: %s", fp)
+	return fp
+}
+
+// normalizePostgres lowercases query and replaces literals, placeholders &
+// casts
+func normalizePostgres(q string) string {
+	var (
+		b    strings.Builder
+		last byte // last non space byte written
+	)
+
+	write := func(s string) {
+		b.WriteString(s)
+		if t := strings.TrimRight(s, " "); t != "" {
+			last = t[len(t)-1]
+		}
+	}
+
+	for i := 0; i < len(q); {
+		c := q[i]
+		switch {
+		// -- comment
+		case c == '-' && strings.HasPrefix(q[i:], "--"):
+			end := strings.IndexByte(q[i:], '\n')
+			if end == -1 {
+				end = len(q) - i
+			}
+			i += end
+			write(" ")
+
+		// /* comment */
+		case c == '/' && strings.HasPrefix(q[i:], "/*"):
+			end := strings.Index(q[i+2:], "*/")
+			if end == -1 {
+				i = len(q)
+			} else {
+				i += end + 4
+			}
+			write(" ")
+
+		// 'string' & E'string'
+		case c == '\'':
+			i = pgSkipString(q, i, false)
+			write("?")
+		case (c == 'e' || c == 'E') && i+1 < len(q) && q[i+1] == '\'' && (i == 0 || !isIdentByte(q[i-1])):
+			i = pgSkipString(q, i+1, true)
+			write("?")
+
+		// "identifier"
+		case c == '"':
+			end := i + 1
+			for end < len(q) {
+				if q[end] == '"' {
+					if end+1 < len(q) && q[end+1] == '"' {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			if end < len(q) {
+				end++
+			}
+			write(q[i:end])
+			i = end
+
+		// $1 placeholder & $tag$string$tag$
+		case c == '$':
+			j := i + 1
+			for j < len(q) && q[j] >= '0' && q[j] <= '9' {
+				j++
+			}
+			if j > i+1 {
+				write("?")
+				i = j
+				continue
+			}
+
+			for j < len(q) && isIdentByte(q[j]) && q[j] != '$' {
+				j++
+			}
+			if j < len(q) && q[j] == '$' {
+				tag := q[i : j+1]
+				end := strings.Index(q[j+1:], tag)
+				if end == -1 {
+					i = len(q)
+				} else {
+					i = j + 1 + end + len(tag)
+				}
+				write("?")
+				continue
+			}
+
+			write(q[i : i+1])
+			i++
+
+		// ::cast
+		case c == ':' && strings.HasPrefix(q[i:], "::"):
+			i = pgSkipCast(q, i+2)
+
+		// numbers (with sign when following an operator)
+		case (c >= '0' && c <= '9') || (c == '-' && i+1 < len(q) && q[i+1] >= '0' && q[i+1] <= '9' && strings.IndexByte("(,=<>+-*/ ", last) != -1):
+			i++
+			for i < len(q) && (q[i] >= '0' && q[i] <= '9' || q[i] == '.') {
+				i++
+			}
+			if i+1 < len(q) && (q[i] == 'e' || q[i] == 'E') && (q[i+1] >= '0' && q[i+1] <= '9' || q[i+1] == '-' || q[i+1] == '+') {
+				i += 2
+				for i < len(q) && q[i] >= '0' && q[i] <= '9' {
+					i++
+				}
+			}
+			write("?")
+
+		// identifiers & keywords
+		case isIdentByte(c):
+			j := i
+			for j < len(q) && isIdentByte(q[j]) {
+				j++
+			}
+			write(strings.ToLower(q[i:j]))
+			i = j
+
+		default:
+			write(q[i : i+1])
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// pgSkipString returns the position following the string literal starting at
+// i; with backslash, \' does not end the string (E” strings)
+func pgSkipString(q string, i int, backslash bool) int {
+	for i++; i < len(q); i++ {
+		switch {
+		case backslash && q[i] == '\\':
+			i++
+		case q[i] == '\'':
+			if i+1 < len(q) && q[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(q)
+}
+
+// pgMultiWordTypes holds type names suffixes spanning several words
+var pgMultiWordTypes = []string{" varying", " precision", " with time zone", " without time zone"}
+
+// pgSkipCast returns the position following the type name of a cast
+// (e.g. "text", "character varying(10)[]", "public.mytype")
+func pgSkipCast(q string, i int) int {
+	for i < len(q) && q[i] == ' ' {
+		i++
+	}
+
+	if i < len(q) && q[i] == '"' {
+		end := strings.IndexByte(q[i+1:], '"')
+		if end == -1 {
+			return len(q)
+		}
+		i += end + 2
+	}
+
+	for i < len(q) && (isIdentByte(q[i]) || q[i] == '.') {
+		i++
+	}
+
+	for _, suffix := range pgMultiWordTypes {
+		if len(q)-i >= len(suffix) && strings.EqualFold(q[i:i+len(suffix)], suffix) {
+			i += len(suffix)
+		}
+	}
+
+	if i < len(q) && q[i] == '(' {
+		if end := strings.IndexByte(q[i:], ')'); end != -1 {
+			i += end + 1
+		}
+	}
+
+	for strings.HasPrefix(q[i:], "[]") {
+		i += 2
+	}
+
+	return i
+}
+
+// isIdentByte tells if c can be part of an unquoted identifier
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
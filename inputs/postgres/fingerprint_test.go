@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprintPostgres(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`SELECT * FROM "Users" WHERE id = $1::int AND name = 'O''Brien'`, `select * from "Users" where id = ? and name = ?`},
+		{`select $tag$it's$tag$ || $$x$$ FROM t`, `select ? || ? from t`},
+		{`select now()::timestamp with time zone, 1.5e3, -2, col1 from t /* c */ -- end`, `select now(), ?, ?, col1 from t`},
+		{"INSERT INTO t (a, b)\n\tVALUES (1, 'a'), (2, 'b')", `insert into t (a, b) values (?)`},
+		{"INSERT INTO t VALUES (1, 'a;b'); UPDATE t SET a = 2", `insert into t values (?); update t set a = ?`},
+		{`select * from t where id in ($1, $2,$3) and c=E'it\'s'::varchar(10)[]`, `select * from t where id in (?) and c = ?`},
+		{`select * from t where id = any(array[1,2]) limit 10 offset 20`, `select * from t where id = any (?) limit ? offset ?`},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, fingerprint(tt.query), "should be equal")
+	}
+}
@@ -0,0 +1,405 @@
+// Package postgres reads PostgreSQL stderr & csvlog logs
+package postgres
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+// logLinePrefix is the log_line_prefix of stderr logs (--pg-log-line-prefix)
+var logLinePrefix string
+
+// pgmessagere matches PostgreSQL duration & statement messages
+// e.g. "duration: 0.123 ms  statement: select 1"
+// or "duration: 0.123 ms  execute <unnamed>: select $1"
+var pgmessagere = regexp.MustCompile(`(?s)^(?:duration: ([0-9\.]+) ms)?\s*(?:(statement|execute [^:]*|parse [^:]*|bind [^:]*): (.*))?$`)
+
+// pgsniffre matches PostgreSQL stderr log messages
+// e.g. "2020-02-26 14:46:34.123 UTC [1234] LOG:  duration: 0.123 ms"
+var pgsniffre = regexp.MustCompile(`(?m)\b(LOG|ERROR|FATAL|PANIC|WARNING|STATEMENT|DETAIL):  `)
+
+// pgcsvsniffre matches PostgreSQL csvlog severity & SQL state columns
+// e.g. `2020-02-26 14:46:34.123 UTC,"postgres","test",...,LOG,00000,"duration: ...`
+var pgcsvsniffre = regexp.MustCompile(`(?m)^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}[^,\n]*,.*,(LOG|ERROR|FATAL|PANIC|WARNING),[0-9A-Z]{5},`)
+
+// CSV log columns (PostgreSQL 9.0+)
+const (
+	pgcsvLogTime        = 0
+	pgcsvUserName       = 1
+	pgcsvDatabaseName   = 2
+	pgcsvProcessID      = 3
+	pgcsvConnectionFrom = 4
+	pgcsvErrorSeverity  = 11
+	pgcsvMessage        = 13
+	pgcsvMinColumns     = 14
+)
+
+// pgMessage holds a PostgreSQL log message and its context
+type pgMessage struct {
+	Time     time.Time
+	User     string
+	Database string
+	Client   string
+	PID      int
+	Severity string
+	Message  string
+}
+
+// pgLogParser turns PostgreSQL log messages into queries
+// Statements logged without duration (log_statement) are kept per backend
+// until a duration (log_duration) shows up for them
+type pgLogParser struct {
+	source  string
+	queries chan<- inputs.Query
+	pending map[int]*inputs.Query
+}
+
+// Reader reads PostgreSQL stderr logs
+type Reader struct{}
+
+// CSVReader reads PostgreSQL csvlog logs
+type CSVReader struct{}
+
+func init() {
+	inputs.Add("postgres", Reader{})
+	inputs.Add("postgres-csv", CSVReader{})
+
+	flag.StringVar(&logLinePrefix, "pg-log-line-prefix", "%m [%p] ", "PostgreSQL log_line_prefix (postgres input format)")
+}
+
+// Options returns the log_line_prefix (--pg-log-line-prefix)
+func (Reader) Options() map[string]string {
+	return map[string]string{"pg-log-line-prefix": logLinePrefix}
+}
+
+// Sniff recognizes PostgreSQL stderr logs
+func (Reader) Sniff(head []byte) int {
+	if pgsniffre.Match(head) {
+		return 90
+	}
+	return 0
+}
+
+// Sniff recognizes PostgreSQL csvlog logs
+func (CSVReader) Sniff(head []byte) int {
+	if pgcsvsniffre.Match(head) {
+		return inputs.Certain
+	}
+	return 0
+}
+
+// Read reads a PostgreSQL stderr log and sends raw queries to workers
+// Lines are split using log_line_prefix (--pg-log-line-prefix)
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	source := src.Name
+
+	prefixre, err := pgPrefixRegexp(logLinePrefix)
+	if err != nil {
+		return fmt.Errorf("invalid log_line_prefix '%s': %v", logLinePrefix, err)
+	}
+
+	// Lines longer than inputs.MaxLineSize are truncated & their message is
+	// counted as truncated
+	scanner, splitter := inputs.NewLineScanner(r)
+
+	parser := &pgLogParser{source: source, queries: events, pending: map[int]*inputs.Query{}}
+
+	var (
+		current   *pgMessage
+		truncated bool // current message has a truncated line
+	)
+
+	read := 0
+	skipped := 0
+	truncatedEntries := 0
+
+	// flush handles the current message, if any
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if truncated {
+			truncatedEntries++
+		}
+		parser.handle(current)
+		current = nil
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		read++
+
+		if splitter.Truncated {
+			log.Debugf("line %d exceeds %d bytes; truncating", read, inputs.MaxLineSize)
+		}
+
+		matches := prefixre.FindStringSubmatch(line)
+		if matches == nil {
+			// Multiline messages continue with a tab; lines are kept verbatim
+			if current != nil && strings.HasPrefix(line, "\t") {
+				current.Message += "\n" + strings.TrimPrefix(line, "\t")
+				truncated = truncated || splitter.Truncated
+			} else {
+				skipped++
+			}
+			continue
+		}
+
+		flush()
+
+		current = &pgMessage{}
+		truncated = splitter.Truncated
+		for i, name := range prefixre.SubexpNames() {
+			if matches[i] == "" {
+				continue
+			}
+			switch name {
+			case "time":
+				current.Time, err = parsePostgresTime(matches[i], src.Location)
+				if err != nil {
+					log.Warnf("unable to parse time '%s' at line %d: %v", matches[i], read, err)
+				}
+			case "user":
+				current.User = matches[i]
+			case "db":
+				current.Database = matches[i]
+			case "client":
+				current.Client = matches[i]
+			case "pid":
+				current.PID, _ = strconv.Atoi(matches[i])
+			case "severity":
+				current.Severity = matches[i]
+			case "message":
+				current.Message = matches[i]
+			}
+		}
+	}
+
+	flush()
+	parser.flushAll()
+
+	src.Lines += read
+	src.SkippedLines += skipped
+	src.TruncatedEntries += truncatedEntries
+	if truncatedEntries > 0 {
+		log.Warnf("truncated %d messages with lines longer than %d bytes in %s", truncatedEntries, inputs.MaxLineSize, source)
+	}
+
+	return scanner.Err()
+}
+
+// Read reads a PostgreSQL csvlog and sends raw queries to workers
+// Records read before an error are still reported
+func (CSVReader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	source := src.Name
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	parser := &pgLogParser{source: source, queries: events, pending: map[int]*inputs.Query{}}
+
+	read := 0
+	skipped := 0
+
+	var readErr error
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = fmt.Errorf("after %d records: %v", read, err)
+			break
+		}
+		read++
+
+		if len(record) < pgcsvMinColumns {
+			skipped++
+			continue
+		}
+
+		msg := &pgMessage{
+			User:     record[pgcsvUserName],
+			Database: record[pgcsvDatabaseName],
+			Severity: record[pgcsvErrorSeverity],
+			Message:  record[pgcsvMessage],
+		}
+		msg.PID, _ = strconv.Atoi(record[pgcsvProcessID])
+
+		// connection_from is "host:port"
+		msg.Client = record[pgcsvConnectionFrom]
+		if idx := strings.LastIndex(msg.Client, ":"); idx != -1 {
+			msg.Client = msg.Client[:idx]
+		}
+
+		msg.Time, err = parsePostgresTime(record[pgcsvLogTime], src.Location)
+		if err != nil {
+			log.Warnf("unable to parse time '%s' at record %d: %v", record[pgcsvLogTime], read, err)
+		}
+
+		parser.handle(msg)
+	}
+
+	parser.flushAll()
+
+	src.Lines += read
+	src.SkippedLines += skipped
+
+	return readErr
+}
+
+// handle processes a log message
+func (p *pgLogParser) handle(msg *pgMessage) {
+	if msg.Severity != "LOG" {
+		return
+	}
+
+	matches := pgmessagere.FindStringSubmatch(msg.Message)
+	if matches == nil || (matches[1] == "" && matches[2] == "") {
+		return
+	}
+
+	// parse & bind durations are part of extended protocol execution
+	if strings.HasPrefix(matches[2], "parse ") || strings.HasPrefix(matches[2], "bind ") {
+		return
+	}
+
+	var duration float64
+	if matches[1] != "" {
+		duration, _ = strconv.ParseFloat(matches[1], 64)
+		duration /= 1000
+	}
+
+	// Duration logged apart from its statement (log_duration)
+	if matches[2] == "" {
+		if qry, ok := p.pending[msg.PID]; ok {
+			delete(p.pending, msg.PID)
+			qry.QueryTime = duration
+			qry.NoTiming = false
+			qry.Start = msg.Time.Add(-time.Duration(duration * float64(time.Second)))
+			qry.Time = msg.Time
+			p.send(qry)
+		}
+		return
+	}
+
+	p.flush(msg.PID)
+
+	qry := &inputs.Query{
+		Source:       p.source,
+		Time:         msg.Time,
+		User:         msg.User,
+		Client:       msg.Client,
+		ConnectionID: msg.PID,
+		Schema:       msg.Database,
+		FullQuery:    matches[3],
+	}
+
+	if matches[1] == "" {
+		// Statement without duration (log_statement); wait for it
+		qry.NoTiming = true
+		p.pending[msg.PID] = qry
+		return
+	}
+
+	qry.QueryTime = duration
+	qry.Start = msg.Time.Add(-time.Duration(duration * float64(time.Second)))
+	p.send(qry)
+}
+
+// flush sends pending statement for pid, if any
+func (p *pgLogParser) flush(pid int) {
+	if qry, ok := p.pending[pid]; ok {
+		delete(p.pending, pid)
+		p.send(qry)
+	}
+}
+
+// flushAll sends all pending statements
+func (p *pgLogParser) flushAll() {
+	for pid := range p.pending {
+		p.flush(pid)
+	}
+}
+
+// send sends query to workers, along with the PostgreSQL fingerprinting
+// hook
+func (p *pgLogParser) send(qry *inputs.Query) {
+	if strings.TrimSpace(qry.FullQuery) == "" {
+		return
+	}
+	qry.Normalize = fingerprint
+	p.queries <- *qry
+}
+
+// pgPrefixRegexp builds a regexp matching log lines from log_line_prefix
+// Captured fields are time (%t, %m, %n), user (%u), db (%d), client (%h,
+// %r) and pid (%p), followed by severity & message
+func pgPrefixRegexp(prefix string) (*regexp.Regexp, error) {
+	const timestamp = `\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?(?: (?:[A-Za-z]{1,5}|[+-]\d{2}(?::?\d{2})?))?`
+
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(prefix); i++ {
+		if prefix[i] != '%' || i+1 == len(prefix) {
+			b.WriteString(regexp.QuoteMeta(prefix[i : i+1]))
+			continue
+		}
+
+		i++
+		switch prefix[i] {
+		case 't', 'm':
+			b.WriteString(`(?P<time>` + timestamp + `)`)
+		case 'n':
+			b.WriteString(`(?P<time>\d+\.\d+)`)
+		case 'u':
+			b.WriteString(`(?P<user>.*?)`)
+		case 'd':
+			b.WriteString(`(?P<db>.*?)`)
+		case 'h':
+			b.WriteString(`(?P<client>.*?)`)
+		case 'r':
+			b.WriteString(`(?P<client>.*?)(?:\(\d+\))?`)
+		case 'p':
+			b.WriteString(`(?P<pid>\d+)`)
+		case 's':
+			b.WriteString(timestamp)
+		case 'l', 'x':
+			b.WriteString(`\d*`)
+		case 'q':
+			// Only a marker for non-session processes
+		case '%':
+			b.WriteString("%")
+		default:
+			b.WriteString(`.*?`)
+		}
+	}
+
+	b.WriteString(`(?P<severity>[A-Z0-9]+):\s+(?P<message>.*)$`)
+
+	return regexp.Compile(b.String())
+}
+
+// parsePostgresTime parses PostgreSQL log timestamps
+// (e.g. "2021-03-01 10:00:00.123 UTC" or epoch "1614592800.123")
+// Timestamps without timezone are in loc
+func parsePostgresTime(s string, loc *time.Location) (time.Time, error) {
+	if epoch, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(epoch*1e9)).UTC(), nil
+	}
+
+	return inputs.ParseTime(s, loc)
+}
@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+// read returns queries read from log by reader
+func read(t *testing.T, reader inputs.Reader, log string) ([]inputs.Query, *inputs.Source) {
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, reader.Read(strings.NewReader(log), src, events))
+	close(events)
+
+	var got []inputs.Query
+	for qry := range events {
+		got = append(got, qry)
+	}
+	return got, src
+}
+
+func TestReadPostgresLog(t *testing.T) {
+	pglog := "2021-03-01 10:00:00.123 UTC [1234] app@shop LOG:  duration: 12.500 ms  statement: SELECT * FROM users\n" +
+		"\tWHERE id = 42\n" +
+		"2021-03-01 10:00:01.000 UTC [1235] app@shop LOG:  duration: 0.500 ms  execute <unnamed>: select * from users where id = $1\n" +
+		"2021-03-01 10:00:01.000 UTC [1235] app@shop DETAIL:  parameters: $1 = '12'\n" +
+		"2021-03-01 10:00:02.000 UTC [1236] app@shop LOG:  statement: update users set a = 1\n" +
+		"2021-03-01 10:00:02.010 UTC [1236] app@shop LOG:  duration: 10.000 ms\n" +
+		"2021-03-01 10:00:03.000 UTC [1236] app@shop LOG:  connection received: host=10.0.0.1 port=5432\n"
+
+	defer func(prefix string) { logLinePrefix = prefix }(logLinePrefix)
+	logLinePrefix = "%m [%p] %u@%d "
+
+	got, _ := read(t, Reader{}, pglog)
+
+	if !assert.Len(t, got, 3) {
+		return
+	}
+
+	assert.Equal(t, "SELECT * FROM users\nWHERE id = 42", got[0].FullQuery, "should be equal")
+	assert.Equal(t, "select * from users where id = ?", got[0].Normalize(got[0].FullQuery), "should be equal")
+	assert.Equal(t, 0.0125, got[0].QueryTime, "should be equal")
+	assert.Equal(t, "app", got[0].User, "should be equal")
+	assert.Equal(t, "shop", got[0].Schema, "should be equal")
+	assert.Equal(t, 1234, got[0].ConnectionID, "should be equal")
+	assert.Equal(t, "2021-03-01 10:00:00.123", got[0].Time.Format("2006-01-02 15:04:05.000"), "should be equal")
+
+	assert.Equal(t, fingerprint(got[0].FullQuery), fingerprint(got[1].FullQuery), "should be equal")
+
+	// Duration logged after statement
+	assert.Equal(t, "update users set a = ?", fingerprint(got[2].FullQuery), "should be equal")
+	assert.Equal(t, 0.01, got[2].QueryTime, "should be equal")
+	assert.False(t, got[2].NoTiming)
+}
+
+func TestReadPostgresCSVLog(t *testing.T) {
+	pgcsv := `2021-03-01 10:00:00.123 UTC,"app","shop",1234,"10.0.0.1:51000",603cb8a0.4d2,1,"SELECT",2021-03-01 09:59:00 UTC,3/0,0,LOG,00000,"duration: 1.000 ms  statement: select ""Id""
+from t where a = 'x'",,,,,,,,,""` + "\n" +
+		`2021-03-01 10:00:01.000 UTC,"app","shop",1234,"10.0.0.1:51000",603cb8a0.4d2,2,"idle",2021-03-01 09:59:00 UTC,3/0,0,LOG,00000,"disconnection: session time: 0:01:00.000",,,,,,,,,""` + "\n"
+
+	got, src := read(t, CSVReader{}, pgcsv)
+
+	if !assert.Len(t, got, 1) {
+		return
+	}
+
+	assert.Equal(t, `select "Id" from t where a = ?`, fingerprint(got[0].FullQuery), "should be equal")
+	assert.Equal(t, "10.0.0.1", got[0].Client, "should be equal")
+	assert.Equal(t, 0.001, got[0].QueryTime, "should be equal")
+	assert.Equal(t, 2, src.Lines, "should be equal")
+}
+
+func TestReadPostgresLogLongLine(t *testing.T) {
+	long := "INSERT INTO t VALUES " + strings.Repeat("(1),", 512*1024) + "(1)"
+	pglog := "2021-03-01 10:00:00.123 UTC [1234] LOG:  duration: 12.500 ms  statement: " + long + "\n" +
+		"2021-03-01 10:00:01.000 UTC [1235] LOG:  duration: 0.500 ms  statement: SELECT 1\n"
+
+	got, src := read(t, Reader{}, pglog)
+
+	if !assert.Len(t, got, 2) {
+		return
+	}
+
+	assert.Equal(t, long, got[0].FullQuery, "should be equal")
+	assert.Equal(t, 2, src.Lines, "should be equal")
+	assert.Equal(t, 0, src.TruncatedEntries, "should be equal")
+}
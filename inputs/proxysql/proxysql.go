@@ -0,0 +1,142 @@
+// Package proxysql reads ProxySQL query events logs
+package proxysql
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+// proxysqlEvent holds a ProxySQL query event (eventslog_format=2)
+// e.g. {"client":"127.0.0.1:39954","digest":"0x226CD90D52A2BA0B",
+// "duration_us":120,"endtime_timestamp_us":1563123963285149,
+// "event":"COM_QUERY","hostgroup_id":0,"query":"select 1",...}
+type proxysqlEvent struct {
+	Client       string `json:"client"`
+	Digest       string `json:"digest"`
+	Duration     int64  `json:"duration_us"`
+	StartTime    int64  `json:"starttime_timestamp_us"`
+	EndTime      int64  `json:"endtime_timestamp_us"`
+	Event        string `json:"event"`
+	Hostgroup    *int   `json:"hostgroup_id"`
+	Query        string `json:"query"`
+	RowsAffected int    `json:"rows_affected"`
+	RowsSent     int    `json:"rows_sent"`
+	Schema       string `json:"schemaname"`
+	Server       string `json:"server"`
+	ThreadID     int    `json:"thread_id"`
+	User         string `json:"username"`
+	Errno        int    `json:"errno"`
+}
+
+// proxysqlEvents holds ProxySQL events carrying a query
+var proxysqlEvents = map[string]bool{
+	"COM_QUERY":        true,
+	"COM_STMT_EXECUTE": true,
+}
+
+// Reader reads ProxySQL events logs
+type Reader struct{}
+
+func init() {
+	inputs.Add("proxysql", Reader{})
+}
+
+// Sniff recognizes ProxySQL JSON events logs
+func (Reader) Sniff(head []byte) int {
+	if bytes.HasPrefix(head, []byte("{")) && bytes.Contains(head, []byte(`"hostgroup_id":`)) {
+		return inputs.Certain
+	}
+	return 0
+}
+
+// Read reads a ProxySQL query events log (JSON lines) and sends raw queries
+// to workers
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	source := src.Name
+
+	// Lines longer than inputs.MaxLineSize can not be decoded once truncated;
+	// their event is counted as truncated
+	scanner, splitter := inputs.NewLineScanner(r)
+
+	read := 0
+	skipped := 0
+	truncatedEntries := 0
+
+	for scanner.Scan() {
+		read++
+
+		if splitter.Truncated {
+			log.Debugf("line %d exceeds %d bytes; skipping", read, inputs.MaxLineSize)
+			truncatedEntries++
+			continue
+		}
+
+		var ev proxysqlEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			log.Debugf("skipping line %d: %v", read, err)
+			skipped++
+			continue
+		}
+
+		// Prepare, close, quit, etc... events
+		if !proxysqlEvents[ev.Event] || ev.Query == "" {
+			continue
+		}
+
+		qry := inputs.Query{
+			Source:       source,
+			User:         ev.User,
+			Client:       ev.Client,
+			ConnectionID: ev.ThreadID,
+			Schema:       ev.Schema,
+			LastErrno:    ev.Errno,
+			QueryTime:    float64(ev.Duration) / 1e6,
+			RowsSent:     ev.RowsSent,
+			RowsAffected: ev.RowsAffected,
+			FullQuery:    ev.Query,
+			Digest:       ev.Digest,
+			Backend:      ev.Server,
+		}
+
+		if host, _, err := net.SplitHostPort(ev.Client); err == nil {
+			qry.Client = host
+		}
+
+		// Queries served by ProxySQL itself (query cache, admin) have no
+		// hostgroup (-1)
+		if ev.Hostgroup != nil && *ev.Hostgroup >= 0 {
+			qry.Hostgroup = strconv.Itoa(*ev.Hostgroup)
+		}
+
+		if ev.EndTime > 0 {
+			qry.Time = time.Unix(0, ev.EndTime*int64(time.Microsecond)).UTC()
+		}
+		if ev.StartTime > 0 {
+			qry.Start = time.Unix(0, ev.StartTime*int64(time.Microsecond)).UTC()
+		}
+
+		if strings.TrimSpace(qry.FullQuery) == "" {
+			skipped++
+			continue
+		}
+
+		events <- qry
+	}
+
+	src.Lines += read
+	src.SkippedLines += skipped
+	src.TruncatedEntries += truncatedEntries
+	if truncatedEntries > 0 {
+		log.Warnf("skipped %d events with lines longer than %d bytes in %s", truncatedEntries, inputs.MaxLineSize, source)
+	}
+
+	return scanner.Err()
+}
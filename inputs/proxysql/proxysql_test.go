@@ -0,0 +1,47 @@
+package proxysql
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+func TestReadProxySQLLog(t *testing.T) {
+	eventslog := `{"client":"10.0.0.5:39954","digest":"0x3765930C7143F468","duration_us":1520,"endtime":"2019-07-14 18:06:03.286549","endtime_timestamp_us":1563127563286549,"event":"COM_QUERY","hostgroup_id":10,"query":"SELECT c FROM sbtest1 WHERE id=42","rows_affected":0,"rows_sent":1,"schemaname":"sbtest","server":"db-1:3306","starttime":"2019-07-14 18:06:03.285029","starttime_timestamp_us":1563127563285029,"thread_id":7,"username":"sbtest"}
+{"client":"10.0.0.5:39954","digest":"0x3765930C7143F468","duration_us":0,"endtime_timestamp_us":1563127563287000,"event":"COM_STMT_PREPARE","hostgroup_id":10,"query":"SELECT c FROM sbtest1 WHERE id=?","schemaname":"sbtest","server":"db-1:3306","starttime_timestamp_us":1563127563287000,"thread_id":7,"username":"sbtest"}
+{"client":"10.0.0.6:40122","digest":"0x3765930C7143F468","duration_us":3000,"endtime_timestamp_us":1563127564000000,"event":"COM_STMT_EXECUTE","hostgroup_id":20,"query":"SELECT c FROM sbtest1 WHERE id=?","rows_sent":1,"schemaname":"sbtest","server":"db-2:3306","starttime_timestamp_us":1563127563997000,"thread_id":8,"username":"sbtest","errno":1146}
+not json
+`
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(eventslog), src, events))
+	close(events)
+
+	var got []inputs.Query
+	for qry := range events {
+		got = append(got, qry)
+	}
+
+	if !assert.Len(t, got, 2) {
+		return
+	}
+
+	assert.Equal(t, "SELECT c FROM sbtest1 WHERE id=42", got[0].FullQuery, "should be equal")
+	assert.Equal(t, "0x3765930C7143F468", got[0].Digest, "should be equal")
+	assert.Equal(t, "10", got[0].Hostgroup, "should be equal")
+	assert.Equal(t, "db-1:3306", got[0].Backend, "should be equal")
+	assert.Equal(t, "10.0.0.5", got[0].Client, "should be equal")
+	assert.Equal(t, "sbtest", got[0].Schema, "should be equal")
+	assert.Equal(t, 7, got[0].ConnectionID, "should be equal")
+	assert.Equal(t, 0.00152, got[0].QueryTime, "should be equal")
+	assert.Equal(t, 1, got[0].RowsSent, "should be equal")
+	assert.Equal(t, "2019-07-14T18:06:03.286549Z", got[0].Time.Format(time.RFC3339Nano), "should be equal")
+
+	assert.Equal(t, "20", got[1].Hostgroup, "should be equal")
+	assert.Equal(t, 1146, got[1].LastErrno, "should be equal")
+	assert.Equal(t, 1, src.SkippedLines, "should be equal")
+}
@@ -0,0 +1,703 @@
+// Package slowlog reads MySQL, Percona Server & MariaDB slow query logs
+package slowlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+	"gitlab.com/devopsworks/tools/dw-query-digest/outputs"
+)
+
+// logentry holds a complete query entry from log file
+// Header lines are kept one per line; multiline queries are kept in a single
+// line, with their newlines
+type logentry struct {
+	lines     []string
+	pos       int
+	truncated bool
+	schema    bool // schema was logged (`# Schema:` or `use`), even if empty
+}
+
+var versionre = regexp.MustCompile(`^([^,]+),\s+Version:\s+([0-9\.]+)([A-Za-z0-9-]*)\s+\((.*)\)\. started`)
+
+// sniffre matches slow log timing lines, which TiDB writes differently
+// e.g. "# Query_time: 0.000171  Lock_time: 0.000054 Rows_sent: 1"
+var sniffre = regexp.MustCompile(`(?m)^# Query_time: \S+\s+Lock_time:`)
+
+// entryre matches slow log entry boundaries
+var entryre = regexp.MustCompile(`(?m)^# (Time|User@Host): `)
+
+// adminPrefix starts administrator commands entries bodies
+// (log_slow_admin_statements)
+const adminPrefix = "# administrator command:"
+
+// Reader reads slow logs
+type Reader struct{}
+
+func init() {
+	inputs.Add("slowlog", Reader{})
+}
+
+// Sniff recognizes slow logs
+// Server headers are shared with the general log, and entry boundaries with
+// TiDB, so they are not decisive
+func (Reader) Sniff(head []byte) int {
+	switch {
+	case sniffre.Match(head):
+		return inputs.Certain
+	case entryre.Match(head):
+		return 50
+	case bytes.Contains(head, []byte("started with:")):
+		return 30
+	}
+	return 0
+}
+
+// Read reads slow log from r and sends queries to events
+// Server headers are optional and can appear anywhere in the file; lines
+// found before the first entry boundary (e.g. when reading a `tail` extract)
+// are skipped and counted
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	// Lines longer than inputs.MaxLineSize are truncated & their entry is
+	// counted as truncated
+	scanner, splitter := inputs.NewLineScanner(r)
+
+	// The entry we'll fill
+	curentry := logentry{}
+	st := &logState{source: src.Name, location: src.Location, statements: src.Statements, events: events, schemas: map[int]string{}}
+
+	// Server header being read; it is recorded once complete
+	var header *outputs.ServerSegment
+
+	flushHeader := func() {
+		if header != nil {
+			src.AddSegment(*header)
+			header = nil
+		}
+	}
+
+	read := 0
+	skipped := 0
+	started := false
+	hasuser := false
+	foldnext := false
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		read++
+
+		// Server headers can show up anywhere in the file
+		// (server restart, FLUSH LOGS, ...) so we record a new segment
+		// each time we see one
+		if IsHeaderStart(line) {
+			flushHeader()
+			header = &outputs.ServerSegment{Source: src.Name, StartLine: read}
+			if err := ParseVersionLine(line, header); err != nil {
+				log.Warnf("unable to parse server header at line %d: %v", read, err)
+			}
+			foldnext = false
+			continue
+		}
+
+		if strings.HasPrefix(line, "Tcp port:") && header != nil {
+			if err := ParseListenersLine(line, header); err != nil {
+				log.Warnf("unable to parse server header at line %d: %v", read, err)
+			}
+			continue
+		}
+
+		flushHeader()
+
+		if IsHeaderColumns(line) {
+			continue
+		}
+
+		// Entries start with `# Time`, but MySQL only writes it when the
+		// second changes, so a second `# User@Host` also starts a new entry
+		isuser := strings.HasPrefix(line, "# User@Host")
+		if strings.HasPrefix(line, "# Time") || (isuser && hasuser) || (isuser && !started) {
+			if started {
+				st.send(&curentry)
+			}
+			started = true
+			hasuser = false
+			foldnext = false
+			curentry = logentry{pos: read}
+		}
+
+		if !started {
+			skipped++
+			continue
+		}
+
+		hasuser = hasuser || isuser
+
+		if splitter.Truncated {
+			log.Debugf("line %d exceeds %d bytes; truncating", read, inputs.MaxLineSize)
+			curentry.truncated = true
+		}
+
+		// Blank lines only matter inside multiline queries
+		if line == "" && !foldnext {
+			continue
+		}
+
+		// Now if line does not end with a ';', this is a multiline query
+		// So we append to previous line, keeping it verbatim
+		if foldnext {
+			curentry.lines[len(curentry.lines)-1] += "\n" + line
+		} else {
+			curentry.lines = append(curentry.lines, line)
+		}
+
+		last := curentry.lines[len(curentry.lines)-1]
+		foldnext = !strings.HasSuffix(last, ";") && !strings.HasPrefix(last, "#")
+		if foldnext {
+			log.Debugf("line (%d) will fold", read+1)
+		}
+	}
+
+	flushHeader()
+
+	// Ship the last curentry
+	if started {
+		st.send(&curentry)
+	} else {
+		log.Errorf("unable to find any '# Time' or '# User@Host' entry")
+	}
+
+	if len(src.Segments) == 0 {
+		log.Infof("no server header found in %s", src.Name)
+	}
+
+	src.Lines += read
+	src.SkippedLines += skipped
+	src.TruncatedEntries += st.truncated
+	if skipped > 0 {
+		log.Warnf("skipped %d lines before first entry in %s; beginning of log might be missing", skipped, src.Name)
+	}
+	if st.truncated > 0 {
+		log.Warnf("truncated %d entries with lines longer than %d bytes in %s", st.truncated, inputs.MaxLineSize, src.Name)
+	}
+
+	return scanner.Err()
+}
+
+// logState holds state shared by the entries of a log
+type logState struct {
+	source     string
+	location   *time.Location
+	statements string // multi-statement entries handling
+	events     chan<- inputs.Query
+	schemas    map[int]string // current schema per connection
+	last       time.Time      // time of the previous entry
+	truncated  int
+}
+
+// send parses entry & sends the resulting query to events
+// Entries without a query are dropped
+func (st *logState) send(entry *logentry) {
+	if entry.truncated {
+		st.truncated++
+	}
+
+	qry, statements := parseEntry(entry, st.location)
+
+	// Entries without any timestamp happened about the same time as the
+	// previous one
+	if qry.Time.IsZero() {
+		qry.Time = st.last
+	} else {
+		st.last = qry.Time
+	}
+
+	// Entries without schema run in the last one logged for their
+	// connection; an empty `# Schema:` means no schema is selected
+	if entry.schema {
+		st.schemas[qry.ConnectionID] = qry.Schema
+	} else {
+		qry.Schema = st.schemas[qry.ConnectionID]
+	}
+
+	if qry.FullQuery == "" {
+		return
+	}
+	qry.Source = st.source
+
+	if len(statements) < 2 || (st.statements != inputs.StatementsSplit && st.statements != inputs.StatementsShared) {
+		st.events <- qry
+		return
+	}
+
+	for _, stmt := range splitQuery(qry, statements, st.statements == inputs.StatementsSplit) {
+		st.events <- stmt
+	}
+}
+
+// splitQuery returns one query per statement of qry
+// Counters (rows, bytes, errors, ...) can not be attributed to statements and
+// are kept on the last one, so totals are unchanged; Query_time & Lock_time
+// are split evenly when split is true, and reported for each statement
+// otherwise
+func splitQuery(qry inputs.Query, statements []string, split bool) []inputs.Query {
+	if split {
+		qry.QueryTime /= float64(len(statements))
+		qry.LockTime /= float64(len(statements))
+	}
+
+	queries := make([]inputs.Query, 0, len(statements))
+	for i, stmt := range statements {
+		q := qry
+		if i < len(statements)-1 {
+			q = inputs.Query{
+				Source:       qry.Source,
+				Time:         qry.Time,
+				Start:        qry.Start,
+				User:         qry.User,
+				AltUser:      qry.AltUser,
+				Client:       qry.Client,
+				ConnectionID: qry.ConnectionID,
+				Schema:       qry.Schema,
+				Routine:      qry.Routine,
+				QueryTime:    qry.QueryTime,
+				LockTime:     qry.LockTime,
+				NoTiming:     qry.NoTiming,
+			}
+		}
+		q.FullQuery = stmt
+		queries = append(queries, q)
+	}
+
+	return queries
+}
+
+// parseEntry parses entry lines into a query, along with the statements of
+// its body
+// Entries can hold several statements (e.g. "BEGIN; UPDATE ...; COMMIT;" sent
+// at once); FullQuery then holds all of them
+// Timestamps without timezone are in loc
+func parseEntry(entry *logentry, loc *time.Location) (inputs.Query, []string) {
+	var qry inputs.Query
+	var bodies, statements []string
+
+	for _, line := range entry.lines {
+		// MariaDB surrounds explain blocks with bare "#" lines
+		if line == "#" {
+			continue
+		}
+
+		if len(line) < 5 {
+			log.Warnf("unable to parse line preamble for '%s'; skipping", line)
+			continue
+		}
+
+		switch strings.ToUpper(line[0:4]) {
+		case "# TI":
+			// # Time: 2018-12-17T15:18:58.744913Z
+			// or
+			// # Time: 190603  3:14:02 // in MySQL 5.5/5.6 & MariaDB
+			t, err := inputs.ParseTime(strings.TrimPrefix(line, "# Time:"), loc)
+			if err != nil {
+				log.Errorf("slowlog: error parsing time at line %d: %v", entry.pos, err)
+				continue
+			}
+			qry.Time = t
+
+		case "# US":
+			ParseUserHost(line, &qry)
+
+		case "# EX":
+			// # explain: id	select_type	table	type	possible_keys	key	key_len	ref	rows	Extra
+			// # explain: 1	SIMPLE	t1	ALL	NULL	NULL	NULL	NULL	1000
+			if strings.HasPrefix(line, "# explain:") {
+				parseExplain(line, &qry)
+			} else {
+				ParseAttributes(line, &qry)
+				entry.schema = entry.schema || hasAttribute(line, "Schema")
+			}
+
+		case "SET ":
+			// SET timestamp=1551089391; holds the statement start time
+			if strings.HasPrefix(strings.ToLower(line), "set timestamp=") && qry.Start.IsZero() {
+				qry.Start = parseTimestamp(line[len("set timestamp="):])
+			}
+
+		case "USE ":
+			// use shop;
+			if qry.Schema == "" {
+				qry.Schema = strings.Trim(strings.TrimSpace(line[4:]), "`;")
+			}
+			entry.schema = true
+
+		case "# AD":
+			// # administrator command: Binlog Dump;
+			// Commands are not SQL, so they get a synthetic fingerprint
+			if strings.HasPrefix(line, adminPrefix) {
+				command := strings.TrimSuffix(strings.TrimSpace(line[len(adminPrefix):]), ";")
+				qry.FullQuery = "administrator command: " + command
+				qry.FingerPrint = strings.ToLower(qry.FullQuery)
+				qry.Admin = true
+			}
+
+		default:
+			// Remaining header lines hold "Key: value" attributes
+			if line[0] == '#' {
+				ParseAttributes(line, &qry)
+				entry.schema = entry.schema || hasAttribute(line, "Schema")
+				continue
+			}
+
+			if strings.TrimSpace(line) == "" {
+				log.Warnf("slowlog: got empty query at line %d", entry.pos)
+				continue
+			}
+			bodies = append(bodies, line)
+			statements = append(statements, inputs.SplitStatements(line)...)
+		}
+	}
+
+	if len(bodies) > 0 && !qry.Admin {
+		qry.FullQuery = strings.Join(bodies, "\n")
+	}
+
+	// `# Time` is the statement end time, but is not written when the second
+	// did not change
+	if qry.Time.IsZero() && !qry.Start.IsZero() {
+		qry.Time = qry.Start.Add(time.Duration(qry.QueryTime * float64(time.Second)))
+	}
+
+	return qry, statements
+}
+
+// parseTimestamp parses a `SET timestamp=` value (seconds since epoch, with
+// an optional fractional part)
+func parseTimestamp(value string) time.Time {
+	ts, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), ";"), 64)
+	if err != nil {
+		log.Warnf("slowlog: unable to parse timestamp '%s': %v", value, err)
+		return time.Time{}
+	}
+
+	sec := int64(ts)
+	return time.Unix(sec, int64((ts-float64(sec))*1e6)*int64(time.Microsecond)).UTC()
+}
+
+// IsHeaderStart returns true if line is the first line of a server header
+// e.g. "/usr/libexec/mysqld, Version: 5.7.19-log (...). started with:"
+func IsHeaderStart(line string) bool {
+	return strings.HasSuffix(line, "started with:") && strings.Contains(line, ", Version: ")
+}
+
+// IsHeaderColumns returns true if line is the last line of a server header
+// e.g. "Time                 Id Command    Argument"
+func IsHeaderColumns(line string) bool {
+	return strings.HasPrefix(line, "Time ") && strings.Contains(line, " Id Command")
+}
+
+// ParseVersionLine parses the first header line into seg
+func ParseVersionLine(line string, seg *outputs.ServerSegment) error {
+	matches := versionre.FindStringSubmatch(line)
+
+	if len(matches) != 5 {
+		seg.Binary = "unable to parse line"
+		seg.VersionShort = seg.Binary
+		seg.Version = seg.Binary
+		seg.VersionDescription = seg.Binary
+		seg.TCPPort = 0
+		seg.UnixSocket = seg.Binary
+		return fmt.Errorf("unable to parse server information; beginning of log might be missing")
+	}
+
+	seg.Binary = matches[1]
+	seg.VersionShort = matches[2]
+	seg.Version = seg.VersionShort + matches[3]
+	seg.VersionDescription = matches[4]
+
+	return nil
+}
+
+// ParseListenersLine parses the second header line into seg
+// e.g. "Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock"
+func ParseListenersLine(line string, seg *outputs.ServerSegment) error {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != "Tcp" {
+		return fmt.Errorf("unable to parse listeners in line '%s'", line)
+	}
+
+	seg.TCPPort, _ = strconv.Atoi(fields[2])
+
+	if idx := strings.Index(line, "Unix socket:"); idx != -1 {
+		seg.UnixSocket = strings.TrimSpace(line[idx+len("Unix socket:"):])
+	}
+
+	return nil
+}
+
+// ParseAttributes parses slow log header lines made of "Key: value" pairs
+// e.g. "# Bytes_sent: 561  Tmp_tables: 0  Tmp_disk_tables: 0  Tmp_table_sizes: 0"
+// Words not followed by a colon (e.g. "# No InnoDB statistics available...")
+// are ignored
+func ParseAttributes(line string, qry *inputs.Query) {
+	fields := strings.Fields(strings.TrimLeft(line, "# "))
+
+	for i := 0; i < len(fields); i++ {
+		if !strings.HasSuffix(fields[i], ":") {
+			continue
+		}
+
+		key := strings.TrimSuffix(fields[i], ":")
+		value := ""
+
+		// Values can be empty (e.g. "# Schema:  Last_errno: 0")
+		if i+1 < len(fields) && !strings.HasSuffix(fields[i+1], ":") {
+			value = fields[i+1]
+			i++
+		}
+
+		setAttribute(qry, key, value)
+	}
+}
+
+// hasAttribute returns true if header line holds attribute key, even with an
+// empty value
+func hasAttribute(line, key string) bool {
+	for _, field := range strings.Fields(line) {
+		if field == key+":" {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseUserHost parses a "# User@Host:" line
+// e.g. "# User@Host: agency[agency] @  [192.168.0.102]  Id: 3502988"
+// or "# User@Host: app[app] @ web1.example.com [10.0.0.1]  Id: 12"
+// Client is the host name when resolved, its IP otherwise
+func ParseUserHost(line string, qry *inputs.Query) {
+	s := strings.TrimPrefix(line, "# User@Host:")
+	s = strings.Replace(s, "[", " ", -1)
+	s = strings.Replace(s, "]", " ", -1)
+
+	fields := strings.Fields(s)
+
+	// Users come before "@", then host name and/or IP, then "Id:"
+	users, hosts := fields, []string(nil)
+	for i, field := range fields {
+		if field == "@" {
+			users, hosts = fields[:i], fields[i+1:]
+			break
+		}
+	}
+
+	if len(users) > 0 {
+		qry.AltUser = users[0]
+	}
+	if len(users) > 1 {
+		qry.User = users[1]
+	}
+
+	for i, field := range hosts {
+		if field == "Id:" {
+			if i+1 < len(hosts) {
+				qry.ConnectionID, _ = strconv.Atoi(hosts[i+1])
+			}
+			break
+		}
+		if i == 0 {
+			qry.Client = field
+		}
+	}
+}
+
+// parseExplain parses a MariaDB "# explain:" line
+// The first line holds column names, subsequent ones hold plan rows
+func parseExplain(line string, qry *inputs.Query) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "# explain:"))
+
+	// Columns are tab separated, but tabs can get lost (e.g. copy & paste)
+	var fields []string
+	if strings.Contains(line, "\t") {
+		fields = strings.Split(line, "\t")
+	} else {
+		fields = strings.Fields(line)
+	}
+
+	if qry.ExplainColumns == nil {
+		qry.ExplainColumns = fields
+		return
+	}
+
+	qry.ExplainRows = append(qry.ExplainRows, fields)
+}
+
+// setAttribute sets query attribute key to value
+func setAttribute(qry *inputs.Query, key, value string) {
+	switch key {
+	case "Query_time":
+		qry.QueryTime, _ = strconv.ParseFloat(value, 64)
+	case "Lock_time":
+		qry.LockTime, _ = strconv.ParseFloat(value, 64)
+	case "Rows_sent":
+		qry.RowsSent, _ = strconv.Atoi(value)
+	case "Rows_examined":
+		qry.RowsExamined, _ = strconv.Atoi(value)
+	case "Rows_affected":
+		qry.RowsAffected, _ = strconv.Atoi(value)
+	case "Bytes_sent":
+		qry.BytesSent, _ = strconv.Atoi(value)
+	case "Thread_id":
+		qry.ConnectionID, _ = strconv.Atoi(value)
+	case "Schema":
+		qry.Schema = value
+	case "Last_errno", "Errno":
+		qry.LastErrno, _ = strconv.Atoi(value)
+	case "Killed":
+		qry.Killed, _ = strconv.Atoi(value)
+	case "Tmp_tables", "Created_tmp_tables":
+		qry.TmpTables, _ = strconv.Atoi(value)
+	case "Tmp_disk_tables", "Created_tmp_disk_tables":
+		qry.TmpDiskTables, _ = strconv.Atoi(value)
+	case "Tmp_table_sizes":
+		qry.TmpTableSizes, _ = strconv.Atoi(value)
+	case "QC_Hit", "QC_hit":
+		qry.QCHit = value == "Yes"
+	case "Full_scan":
+		qry.HasQueryPlan = true
+		qry.FullScan = value == "Yes"
+	case "Full_join":
+		qry.HasQueryPlan = true
+		qry.FullJoin = value == "Yes"
+	case "Tmp_table":
+		qry.HasQueryPlan = true
+		qry.TmpTable = value == "Yes"
+	case "Tmp_table_on_disk":
+		qry.HasQueryPlan = true
+		qry.TmpTableOnDisk = value == "Yes"
+	case "Filesort":
+		qry.HasQueryPlan = true
+		qry.Filesort = value == "Yes"
+	case "Filesort_on_disk":
+		qry.HasQueryPlan = true
+		qry.FilesortOnDisk = value == "Yes"
+	case "Merge_passes", "Sort_merge_passes":
+		qry.MergePasses, _ = strconv.Atoi(value)
+	case "InnoDB_IO_r_ops":
+		qry.HasInnoDB = true
+		qry.InnoDBIOReadOps, _ = strconv.Atoi(value)
+	case "InnoDB_IO_r_bytes":
+		qry.HasInnoDB = true
+		qry.InnoDBIOReadBytes, _ = strconv.Atoi(value)
+	case "InnoDB_IO_r_wait":
+		qry.HasInnoDB = true
+		qry.InnoDBIOReadWait, _ = strconv.ParseFloat(value, 64)
+	case "InnoDB_rec_lock_wait":
+		qry.HasInnoDB = true
+		qry.InnoDBRecLockWait, _ = strconv.ParseFloat(value, 64)
+	case "InnoDB_queue_wait":
+		qry.HasInnoDB = true
+		qry.InnoDBQueueWait, _ = strconv.ParseFloat(value, 64)
+	case "InnoDB_pages_distinct":
+		qry.HasInnoDB = true
+		qry.InnoDBPagesDistinct, _ = strconv.Atoi(value)
+	case "Bytes_received":
+		qry.HasSlowExtra = true
+		qry.BytesReceived, _ = strconv.Atoi(value)
+	case "Read_first":
+		qry.HasSlowExtra = true
+		qry.ReadFirst, _ = strconv.Atoi(value)
+	case "Read_last":
+		qry.HasSlowExtra = true
+		qry.ReadLast, _ = strconv.Atoi(value)
+	case "Read_key":
+		qry.HasSlowExtra = true
+		qry.ReadKey, _ = strconv.Atoi(value)
+	case "Read_next":
+		qry.HasSlowExtra = true
+		qry.ReadNext, _ = strconv.Atoi(value)
+	case "Read_prev":
+		qry.HasSlowExtra = true
+		qry.ReadPrev, _ = strconv.Atoi(value)
+	case "Read_rnd":
+		qry.HasSlowExtra = true
+		qry.ReadRnd, _ = strconv.Atoi(value)
+	case "Read_rnd_next":
+		qry.HasSlowExtra = true
+		qry.ReadRndNext, _ = strconv.Atoi(value)
+	case "Sort_range_count":
+		qry.HasSlowExtra = true
+		qry.SortRangeCount, _ = strconv.Atoi(value)
+	case "Sort_rows":
+		qry.HasSlowExtra = true
+		qry.SortRows, _ = strconv.Atoi(value)
+	case "Sort_scan_count":
+		qry.HasSlowExtra = true
+		qry.SortScanCount, _ = strconv.Atoi(value)
+	case "Start":
+		// Start & End are exact statement boundaries; End supersedes `# Time`
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			qry.Start = t
+		}
+	case "End":
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			qry.Time = t
+		}
+	case "Stored_routine":
+		qry.Routine = value
+	case "Digest":
+		qry.Digest = value
+	case "Conn_ID":
+		qry.ConnectionID, _ = strconv.Atoi(value)
+	case "DB":
+		qry.Schema = value
+	case "Succ":
+		// TiDB does not log error codes
+		if value == "false" {
+			qry.LastErrno = 1
+		}
+	case "Parse_time":
+		qry.HasTiDB = true
+		qry.ParseTime, _ = strconv.ParseFloat(value, 64)
+	case "Compile_time":
+		qry.HasTiDB = true
+		qry.CompileTime, _ = strconv.ParseFloat(value, 64)
+	case "Cop_time":
+		qry.HasTiDB = true
+		qry.CopTime, _ = strconv.ParseFloat(value, 64)
+	case "Process_time":
+		qry.HasTiDB = true
+		qry.ProcessTime, _ = strconv.ParseFloat(value, 64)
+	case "Wait_time":
+		qry.HasTiDB = true
+		qry.WaitTime, _ = strconv.ParseFloat(value, 64)
+	case "Backoff_time":
+		qry.HasTiDB = true
+		qry.BackoffTime, _ = strconv.ParseFloat(value, 64)
+	case "Process_keys":
+		qry.HasTiDB = true
+		qry.ProcessKeys, _ = strconv.Atoi(value)
+	case "Total_keys":
+		qry.HasTiDB = true
+		qry.TotalKeys, _ = strconv.Atoi(value)
+	case "Num_cop_tasks":
+		qry.HasTiDB = true
+		qry.CopTasks, _ = strconv.Atoi(value)
+	case "Write_keys":
+		qry.HasTiDB = true
+		qry.WriteKeys, _ = strconv.Atoi(value)
+	case "Mem_max":
+		qry.HasTiDB = true
+		qry.MemMax, _ = strconv.Atoi(value)
+	case "Disk_max":
+		qry.HasTiDB = true
+		qry.DiskMax, _ = strconv.Atoi(value)
+	default:
+		log.Debugf("ignoring unknown attribute %s", key)
+	}
+}
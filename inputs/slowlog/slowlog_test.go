@@ -0,0 +1,513 @@
+package slowlog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+	"gitlab.com/devopsworks/tools/dw-query-digest/outputs"
+)
+
+func TestParseHeader(t *testing.T) {
+	// 	s := `/usr/sbin/mysqld, Version: 5.7.19-17-57-log (Percona XtraDB Cluster (GPL), Release rel17, Revision 35cdc81, WSREP version 29.22, wsrep_29.22). started with:
+	// Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
+	// Time                 Id Command    Argument`
+
+	var headertests = []struct {
+		label  string
+		hasErr bool
+		header string
+		out    outputs.ServerInfo
+	}{
+		{
+			label:  "good header",
+			hasErr: false,
+			header: `/usr/sbin/mysqld, Version: 5.7.19-17-57-log (Percona XtraDB Cluster (GPL), Release rel17, Revision 35cdc81, WSREP version 29.22, wsrep_29.22). started with:
+Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
+Time                 Id Command    Argument`,
+			out: outputs.ServerInfo{
+				Binary:             "/usr/sbin/mysqld",
+				VersionShort:       "5.7.19",
+				Version:            "5.7.19-17-57-log",
+				VersionDescription: "Percona XtraDB Cluster (GPL), Release rel17, Revision 35cdc81, WSREP version 29.22, wsrep_29.22",
+				TCPPort:            3306,
+				UnixSocket:         "/var/run/mysqld/mysqld.sock",
+			},
+		},
+		{
+			label:  "libexec header without version suffix",
+			hasErr: false,
+			header: `/usr/libexec/mysqld, Version: 8.0.21 (MySQL Community Server - GPL). started with:
+Tcp port: 3307  Unix socket: /var/lib/mysql/mysql.sock
+Time                 Id Command    Argument`,
+			out: outputs.ServerInfo{
+				Binary:             "/usr/libexec/mysqld",
+				VersionShort:       "8.0.21",
+				Version:            "8.0.21",
+				VersionDescription: "MySQL Community Server - GPL",
+				TCPPort:            3307,
+				UnixSocket:         "/var/lib/mysql/mysql.sock",
+			},
+		},
+		{
+			label:  "bad header",
+			hasErr: true,
+
+			header: `crap`,
+			out: outputs.ServerInfo{
+				Binary:             "unable to parse line",
+				VersionShort:       "unable to parse line",
+				Version:            "unable to parse line",
+				VersionDescription: "unable to parse line",
+				TCPPort:            0,
+				UnixSocket:         "unable to parse line",
+			},
+		},
+	}
+
+	for _, tt := range headertests {
+		t.Run(tt.label, func(t *testing.T) {
+			lines := strings.Split(tt.header, "\n")
+
+			servermeta := outputs.ServerSegment{}
+
+			err := ParseVersionLine(lines[0], &servermeta)
+			if err == nil {
+				err = ParseListenersLine(lines[1], &servermeta)
+			}
+
+			if tt.hasErr {
+				assert.NotNil(t, err)
+			} else {
+
+				assert.Nil(t, err)
+			}
+
+			assert.Equal(t, tt.out.Binary, servermeta.Binary, "should be equal")
+			assert.Equal(t, tt.out.VersionShort, servermeta.VersionShort, "should be equal")
+			assert.Equal(t, tt.out.Version, servermeta.Version, "should be equal")
+			assert.Equal(t, tt.out.VersionDescription, servermeta.VersionDescription, "should be equal")
+			assert.Equal(t, tt.out.TCPPort, servermeta.TCPPort, "should be equal")
+			assert.Equal(t, tt.out.UnixSocket, servermeta.UnixSocket, "should be equal")
+		})
+
+	}
+}
+
+func TestReadHeaders(t *testing.T) {
+	slowlog := `/usr/sbin/mysqld, Version: 5.7.19-log (MySQL Community Server (GPL)). started with:
+Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
+Time                 Id Command    Argument
+# Time: 2018-12-17T15:18:58.744913Z
+# User@Host: root[root] @ localhost []  Id: 3
+# Query_time: 0.000030  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SELECT 1;
+/usr/libexec/mysqld, Version: 8.0.21 (MySQL Community Server - GPL). started with:
+Tcp port: 3307  Unix socket: /var/lib/mysql/mysql.sock
+Time                 Id Command    Argument
+# Time: 2018-12-17T15:19:58.744913Z
+# User@Host: root[root] @ localhost []  Id: 4
+# Query_time: 0.000030  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SELECT 2;
+`
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(slowlog), src, events))
+	close(events)
+
+	var got []string
+	for qry := range events {
+		assert.False(t, qry.Time.IsZero(), "entry should start with '# Time'")
+		got = append(got, qry.FullQuery)
+	}
+	assert.Equal(t, []string{"SELECT 1;", "SELECT 2;"}, got, "should be equal")
+
+	assert.Len(t, src.Segments, 2)
+	assert.Equal(t, 1, src.Segments[0].StartLine, "should be equal")
+	assert.Equal(t, "5.7.19-log", src.Segments[0].Version, "should be equal")
+	assert.Equal(t, 8, src.Segments[1].StartLine, "should be equal")
+	assert.Equal(t, "test", src.Segments[1].Source, "should be equal")
+	assert.Equal(t, "/usr/libexec/mysqld", src.Segments[1].Binary, "should be equal")
+	assert.Equal(t, "8.0.21", src.Segments[1].Version, "should be equal")
+	assert.Equal(t, 3307, src.Segments[1].TCPPort, "should be equal")
+	assert.Equal(t, "/var/lib/mysql/mysql.sock", src.Segments[1].UnixSocket, "should be equal")
+}
+
+func TestReadPartialLog(t *testing.T) {
+	// Extract starting in the middle of an entry, without `# Time` lines
+	// as written by MySQL when the second does not change
+	slowlog := `# Query_time: 0.000030  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SELECT 1;
+# User@Host: root[root] @ localhost []  Id: 3
+# Query_time: 0.000030  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SELECT 2;
+# User@Host: root[root] @ localhost []  Id: 4
+# Query_time: 0.000030  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SELECT 3;
+`
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(slowlog), src, events))
+	close(events)
+
+	var got []string
+	for qry := range events {
+		assert.Equal(t, "root", qry.User, "entry should start with '# User@Host'")
+		got = append(got, qry.FullQuery)
+	}
+
+	assert.Equal(t, []string{"SELECT 2;", "SELECT 3;"}, got, "should be equal")
+	assert.Equal(t, 2, src.SkippedLines, "should be equal")
+	assert.Len(t, src.Segments, 0)
+}
+
+// parse parses a single entry
+func parse(entry string) inputs.Query {
+	e := logentry{lines: strings.Split(entry, "\n")}
+	qry, _ := parseEntry(&e, nil)
+	return qry
+}
+
+func TestParseEntryPerconaExtended(t *testing.T) {
+	qry := parse(`# Schema: shop  Last_errno: 1054  Killed: 0
+# Query_time: 1.500000  Lock_time: 0.000100  Rows_sent: 10  Rows_examined: 2000  Rows_affected: 0
+# Bytes_sent: 561  Tmp_tables: 1  Tmp_disk_tables: 1  Tmp_table_sizes: 16384
+# QC_Hit: No  Full_scan: Yes  Full_join: No  Tmp_table: Yes  Tmp_table_on_disk: Yes
+# Filesort: Yes  Filesort_on_disk: No  Merge_passes: 2
+#   InnoDB_IO_r_ops: 3  InnoDB_IO_r_bytes: 49152  InnoDB_IO_r_wait: 0.000500
+#   InnoDB_rec_lock_wait: 0.000000  InnoDB_queue_wait: 0.000200
+#   InnoDB_pages_distinct: 12
+SELECT * FROM products ORDER BY price;`)
+
+	assert.Equal(t, "shop", qry.Schema, "should be equal")
+	assert.Equal(t, 1054, qry.LastErrno, "should be equal")
+	assert.Equal(t, 1.5, qry.QueryTime, "should be equal")
+	assert.Equal(t, 2000, qry.RowsExamined, "should be equal")
+	assert.Equal(t, 561, qry.BytesSent, "should be equal")
+	assert.Equal(t, 1, qry.TmpDiskTables, "should be equal")
+	assert.Equal(t, 16384, qry.TmpTableSizes, "should be equal")
+	assert.True(t, qry.HasQueryPlan)
+	assert.False(t, qry.QCHit)
+	assert.True(t, qry.FullScan)
+	assert.False(t, qry.FullJoin)
+	assert.True(t, qry.TmpTableOnDisk)
+	assert.True(t, qry.Filesort)
+	assert.Equal(t, 2, qry.MergePasses, "should be equal")
+	assert.True(t, qry.HasInnoDB)
+	assert.Equal(t, 3, qry.InnoDBIOReadOps, "should be equal")
+	assert.Equal(t, 0.0002, qry.InnoDBQueueWait, "should be equal")
+	assert.Equal(t, 12, qry.InnoDBPagesDistinct, "should be equal")
+	assert.Equal(t, "SELECT * FROM products ORDER BY price;", qry.FullQuery, "should be equal")
+}
+
+func TestParseEntryMySQLSlowExtra(t *testing.T) {
+	qry := parse(`# Time: 2019-02-25T10:09:51.178210Z
+# User@Host: root[root] @ localhost []  Id:     8
+# Query_time: 0.000040  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20 Thread_id: 8 Errno: 1146 Killed: 0 Bytes_received: 35 Bytes_sent: 120 Read_first: 1 Read_last: 0 Read_key: 2 Read_next: 3 Read_prev: 0 Read_rnd: 4 Read_rnd_next: 21 Sort_merge_passes: 1 Sort_range_count: 0 Sort_rows: 10 Sort_scan_count: 1 Created_tmp_disk_tables: 1 Created_tmp_tables: 2 Start: 2019-02-25T10:09:51.178164Z End: 2019-02-25T10:09:51.178204Z
+SET timestamp=1551089391;
+SELECT * FROM t ORDER BY a;`)
+
+	assert.Equal(t, 0.00004, qry.QueryTime, "should be equal")
+	assert.Equal(t, 20, qry.RowsExamined, "should be equal")
+	assert.Equal(t, 8, qry.ConnectionID, "should be equal")
+	assert.Equal(t, 1146, qry.LastErrno, "should be equal")
+	assert.True(t, qry.HasSlowExtra)
+	assert.Equal(t, 35, qry.BytesReceived, "should be equal")
+	assert.Equal(t, 120, qry.BytesSent, "should be equal")
+	assert.Equal(t, 1, qry.ReadFirst, "should be equal")
+	assert.Equal(t, 2, qry.ReadKey, "should be equal")
+	assert.Equal(t, 3, qry.ReadNext, "should be equal")
+	assert.Equal(t, 4, qry.ReadRnd, "should be equal")
+	assert.Equal(t, 21, qry.ReadRndNext, "should be equal")
+	assert.Equal(t, 1, qry.MergePasses, "should be equal")
+	assert.Equal(t, 10, qry.SortRows, "should be equal")
+	assert.Equal(t, 1, qry.SortScanCount, "should be equal")
+	assert.Equal(t, 1, qry.TmpDiskTables, "should be equal")
+	assert.Equal(t, 2, qry.TmpTables, "should be equal")
+	assert.Equal(t, "2019-02-25T10:09:51.178164Z", qry.Start.Format(time.RFC3339Nano), "should be equal")
+	assert.Equal(t, "2019-02-25T10:09:51.178204Z", qry.Time.Format(time.RFC3339Nano), "should be equal")
+}
+
+func TestParseEntryMariaDBVerbosity(t *testing.T) {
+	qry := parse("# Thread_id: 8  Schema: shop  QC_hit: Yes\n" +
+		"# Query_time: 0.000180  Lock_time: 0.000063  Rows_sent: 1  Rows_examined: 1000\n" +
+		"# Full_scan: Yes  Full_join: No  Tmp_table: No  Tmp_table_on_disk: No\n" +
+		"#\n" +
+		"# explain: id\tselect_type\ttable\ttype\tpossible_keys\tkey\tkey_len\tref\trows\tExtra\n" +
+		"# explain: 1\tSIMPLE\tt1\tALL\tNULL\tNULL\tNULL\tNULL\t1000\tUsing where; Using filesort\n" +
+		"#\n" +
+		"select * from t1 where a > 3 order by b;")
+
+	assert.Equal(t, 8, qry.ConnectionID, "should be equal")
+	assert.Equal(t, "shop", qry.Schema, "should be equal")
+	assert.Equal(t, 0, qry.LastErrno, "should be equal")
+	assert.True(t, qry.QCHit)
+	assert.True(t, qry.FullScan)
+	assert.Equal(t, 1000, qry.RowsExamined, "should be equal")
+	assert.Equal(t, "type", qry.ExplainColumns[3], "should be equal")
+	assert.Len(t, qry.ExplainRows, 1)
+	assert.Equal(t, "ALL", qry.ExplainRows[0][3], "should be equal")
+	assert.Equal(t, "Using where; Using filesort", qry.ExplainRows[0][9], "should be equal")
+	assert.Equal(t, "select * from t1 where a > 3 order by b;", qry.FullQuery, "should be equal")
+}
+
+func TestReadLongEntry(t *testing.T) {
+	slowlog := `# Time: 2019-02-25T10:09:51.178210Z
+# User@Host: root[root] @ localhost []  Id:     8
+# Thread_id: 8  Schema: shop  QC_hit: No
+# Query_time: 1.500000  Lock_time: 0.000100  Rows_sent: 10  Rows_examined: 2000  Rows_affected: 0
+# Bytes_sent: 561  Tmp_tables: 1  Tmp_disk_tables: 1  Tmp_table_sizes: 16384
+# QC_Hit: No  Full_scan: Yes  Full_join: No  Tmp_table: Yes  Tmp_table_on_disk: Yes
+# Filesort: Yes  Filesort_on_disk: No  Merge_passes: 2
+#   InnoDB_IO_r_ops: 3  InnoDB_IO_r_bytes: 49152  InnoDB_IO_r_wait: 0.000500
+#   InnoDB_rec_lock_wait: 0.000000  InnoDB_queue_wait: 0.000200
+#   InnoDB_pages_distinct: 12
+use shop;
+SET timestamp=1551089391;
+SELECT *
+FROM products
+
+WHERE price > 10
+ORDER BY price;
+`
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(slowlog), src, events))
+	close(events)
+
+	qry := <-events
+	assert.Equal(t, "SELECT *\nFROM products\n\nWHERE price > 10\nORDER BY price;", qry.FullQuery, "should be equal")
+	assert.Equal(t, 12, qry.InnoDBPagesDistinct, "should be equal")
+	assert.Equal(t, 0, src.TruncatedEntries, "should be equal")
+}
+
+func TestReadSchemaAndTimestamps(t *testing.T) {
+	slowlog := `# Time: 2019-02-25T10:09:51.178210Z
+# User@Host: root[root] @ localhost []  Id:     8
+# Query_time: 2.000000  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+use shop;
+SET timestamp=1551089389;
+SELECT * FROM products;
+# User@Host: app[app] @ localhost []  Id:     9
+# Query_time: 0.500000  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SET timestamp=1551089390;
+SELECT * FROM orders;
+# Time: 2019-02-25T10:09:53.000000Z
+# User@Host: root[root] @ localhost []  Id:     8
+# Schema: billing  Last_errno: 0  Killed: 0
+# Query_time: 0.000040  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SET timestamp=1551089393;
+SELECT * FROM invoices;
+# User@Host: app[app] @ localhost []  Id:     9
+# Query_time: 0.000040  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+use crm;
+SELECT * FROM customers;
+# User@Host: root[root] @ localhost []  Id:     8
+# Query_time: 0.000040  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SELECT * FROM payments;
+# User@Host: root[root] @ localhost []  Id:     8
+# Schema:   Last_errno: 0  Killed: 0
+# Query_time: 0.000040  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SELECT 1;
+# User@Host: root[root] @ localhost []  Id:     8
+# Query_time: 0.000040  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SELECT 2;
+`
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(slowlog), src, events))
+	close(events)
+
+	var got []inputs.Query
+	for qry := range events {
+		got = append(got, qry)
+	}
+
+	if assert.Len(t, got, 7) {
+		assert.Equal(t, "shop", got[0].Schema, "should be equal")
+		assert.Equal(t, "2019-02-25T10:09:49Z", got[0].Start.Format(time.RFC3339Nano), "should be equal")
+		assert.Equal(t, "2019-02-25T10:09:51.17821Z", got[0].Time.Format(time.RFC3339Nano), "should be equal")
+
+		// No schema known for this connection, no `# Time` when second
+		// does not change
+		assert.Equal(t, "", got[1].Schema, "should be equal")
+		assert.Equal(t, "2019-02-25T10:09:50Z", got[1].Start.Format(time.RFC3339Nano), "should be equal")
+		assert.Equal(t, "2019-02-25T10:09:50.5Z", got[1].Time.Format(time.RFC3339Nano), "should be equal")
+
+		assert.Equal(t, "billing", got[2].Schema, "should be equal")
+		assert.Equal(t, "crm", got[3].Schema, "should be equal")
+
+		// No `use` when schema does not change for the connection
+		assert.Equal(t, "billing", got[4].Schema, "should be equal")
+
+		// Empty `# Schema:` when no schema is selected
+		assert.Equal(t, "", got[5].Schema, "should be equal")
+		assert.Equal(t, "", got[6].Schema, "should be equal")
+	}
+}
+
+func TestParseUserHost(t *testing.T) {
+	var tests = []struct {
+		line   string
+		user   string
+		client string
+		id     int
+	}{
+		{"# User@Host: agency[agency] @  [192.168.0.102]  Id: 3502988", "agency", "192.168.0.102", 3502988},
+		{"# User@Host: app[app] @ web1.example.com [10.0.0.1]  Id: 12", "app", "web1.example.com", 12},
+		{"# User@Host: root[root] @ localhost []  Id:     8", "root", "localhost", 8},
+		{"# User@Host: root[root] @ localhost []", "root", "localhost", 0},
+	}
+
+	for _, tt := range tests {
+		qry := inputs.Query{}
+		ParseUserHost(tt.line, &qry)
+		assert.Equal(t, tt.user, qry.User, tt.line)
+		assert.Equal(t, tt.client, qry.Client, tt.line)
+		assert.Equal(t, tt.id, qry.ConnectionID, tt.line)
+	}
+}
+
+func TestReadResolvedHostSchemas(t *testing.T) {
+	slowlog := `# Time: 2019-02-25T10:09:51.178210Z
+# User@Host: app[app] @ web1.example.com [10.0.0.1]  Id: 12
+# Query_time: 0.500000  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+use shop;
+SELECT * FROM products;
+# User@Host: app[app] @ web2.example.com [10.0.0.2]  Id: 13
+# Query_time: 0.500000  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SELECT * FROM orders;
+# User@Host: app[app] @ web1.example.com [10.0.0.1]  Id: 12
+# Query_time: 0.500000  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SELECT * FROM carts;
+`
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(slowlog), src, events))
+	close(events)
+
+	var got []inputs.Query
+	for qry := range events {
+		got = append(got, qry)
+	}
+
+	if assert.Len(t, got, 3) {
+		assert.Equal(t, 12, got[0].ConnectionID, "should be equal")
+		assert.Equal(t, "shop", got[0].Schema, "should be equal")
+		assert.Equal(t, 13, got[1].ConnectionID, "should be equal")
+		assert.Equal(t, "", got[1].Schema, "should be equal")
+		assert.Equal(t, "shop", got[2].Schema, "should be equal")
+	}
+}
+
+func TestReadMariaDBTimes(t *testing.T) {
+	slowlog := `# User@Host: root[root] @ localhost []
+# Query_time: 0.000040  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SELECT 1;
+# Time: 190603  3:14:02
+# User@Host: root[root] @ localhost []
+# Query_time: 0.000040  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SELECT 2;
+# User@Host: root[root] @ localhost []
+# Query_time: 0.000040  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20
+SELECT 3;
+`
+	src := &inputs.Source{Name: "test", Location: time.FixedZone("CEST", 2*3600)}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(slowlog), src, events))
+	close(events)
+
+	var got []string
+	for qry := range events {
+		got = append(got, qry.Time.UTC().Format(time.RFC3339))
+	}
+
+	// Entries without `# Time` inherit the previous one, if any
+	assert.Equal(t, []string{"0001-01-01T00:00:00Z", "2019-06-03T01:14:02Z", "2019-06-03T01:14:02Z"}, got, "should be equal")
+}
+
+func TestParseEntryAdminCommand(t *testing.T) {
+	qry := parse(`# Time: 2019-02-25T10:09:51.178210Z
+# User@Host: repl[repl] @ replica []  Id:    12
+# Query_time: 3600.000040  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 0
+SET timestamp=1551085791;
+# administrator command: Binlog Dump;`)
+
+	assert.True(t, qry.Admin)
+	assert.Equal(t, "administrator command: Binlog Dump", qry.FullQuery, "should be equal")
+	assert.Equal(t, "administrator command: binlog dump", qry.FingerPrint, "should be equal")
+	assert.Equal(t, 3600.00004, qry.QueryTime, "should be equal")
+}
+
+func TestParseEntryStoredRoutine(t *testing.T) {
+	qry := parse(`# Time: 190603 23:14:02
+# User@Host: app[app] @ localhost []  Id:    7
+# Schema: shop  Last_errno: 0  Killed: 0
+# Query_time: 0.200000  Lock_time: 0.000100  Rows_sent: 0  Rows_examined: 1200  Rows_affected: 3
+# Bytes_sent: 52
+# Stored_routine: shop.refresh_stock
+SET timestamp=1559603642;
+UPDATE stock SET qty = qty - 1 WHERE id = 42;`)
+
+	assert.Equal(t, "shop.refresh_stock", qry.Routine, "should be equal")
+	assert.Equal(t, "UPDATE stock SET qty = qty - 1 WHERE id = 42;", qry.FullQuery, "should be equal")
+	assert.Equal(t, 0.2, qry.QueryTime, "should be equal")
+}
+
+func TestReadMultiStatements(t *testing.T) {
+	slowlog := `# Time: 2019-02-25T10:09:51.178210Z
+# User@Host: app[app] @ localhost []  Id:    12
+# Query_time: 0.300000  Lock_time: 0.000300 Rows_sent: 0  Rows_examined: 10  Rows_affected: 1
+use shop;
+SET timestamp=1551089391;
+BEGIN; UPDATE stock SET qty = 2 WHERE id = 1;
+COMMIT;
+`
+	for _, tt := range []struct {
+		mode      string
+		queries   []string
+		queryTime float64
+	}{
+		{"", []string{"BEGIN; UPDATE stock SET qty = 2 WHERE id = 1;\nCOMMIT;"}, 0.3},
+		{inputs.StatementsCompound, []string{"BEGIN; UPDATE stock SET qty = 2 WHERE id = 1;\nCOMMIT;"}, 0.3},
+		{inputs.StatementsSplit, []string{"BEGIN;", "UPDATE stock SET qty = 2 WHERE id = 1;", "COMMIT;"}, 0.1},
+		{inputs.StatementsShared, []string{"BEGIN;", "UPDATE stock SET qty = 2 WHERE id = 1;", "COMMIT;"}, 0.3},
+	} {
+		events := make(chan inputs.Query, 10)
+		src := &inputs.Source{Name: "test", Statements: tt.mode}
+
+		err := (Reader{}).Read(strings.NewReader(slowlog), src, events)
+		close(events)
+		assert.NoError(t, err)
+
+		var got []inputs.Query
+		for q := range events {
+			got = append(got, q)
+		}
+
+		if !assert.Len(t, got, len(tt.queries), tt.mode) {
+			continue
+		}
+
+		rows := 0
+		for i, q := range got {
+			assert.Equal(t, tt.queries[i], q.FullQuery, tt.mode)
+			assert.InDelta(t, tt.queryTime, q.QueryTime, 1e-9, tt.mode)
+			assert.Equal(t, "shop", q.Schema, tt.mode)
+			assert.Equal(t, 12, q.ConnectionID, tt.mode)
+			assert.Equal(t, "2019-02-25T10:09:51Z", q.Start.Format(time.RFC3339), tt.mode)
+			rows += q.RowsExamined
+		}
+
+		// Counters are only reported once
+		assert.Equal(t, 10, rows, tt.mode)
+	}
+}
@@ -0,0 +1,63 @@
+package inputs
+
+import "strings"
+
+// NormalizeStatements applies normalize to each statement of q and joins
+// them back, so that patterns matching up to the end of a statement (e.g.
+// INSERT ... VALUES) do not swallow the following statements
+func NormalizeStatements(q string, normalize func(stmt string) string) string {
+	statements := SplitStatements(q)
+	if len(statements) < 2 {
+		return normalize(q)
+	}
+
+	// Semicolons are set aside, so that statements stay separated
+	for i, stmt := range statements {
+		if strings.HasSuffix(stmt, ";") {
+			statements[i] = normalize(strings.TrimSuffix(stmt, ";")) + ";"
+		} else {
+			statements[i] = normalize(stmt)
+		}
+	}
+	return strings.Join(statements, " ")
+}
+
+// SplitStatements splits body on semicolons found outside of quotes &
+// comments; statements keep their semicolon
+func SplitStatements(body string) []string {
+	var statements []string
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '/' && strings.HasPrefix(body[i:], "/*"):
+			if end := strings.Index(body[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(body)
+			}
+		case c == ';':
+			if stmt := strings.TrimSpace(body[start : i+1]); stmt != ";" {
+				statements = append(statements, stmt)
+			}
+			start = i + 1
+		}
+	}
+
+	if stmt := strings.TrimSpace(body[start:]); stmt != "" {
+		statements = append(statements, stmt)
+	}
+
+	return statements
+}
@@ -0,0 +1,23 @@
+package inputs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{`select 1;`, []string{`select 1;`}},
+		{`BEGIN; UPDATE t SET a = 1 WHERE id = 2; COMMIT;`, []string{`BEGIN;`, `UPDATE t SET a = 1 WHERE id = 2;`, `COMMIT;`}},
+		{`insert into t values ('a;b', "c;d", 'e\';f'); select 2`, []string{`insert into t values ('a;b', "c;d", 'e\';f');`, `select 2`}},
+		{"select `a;b` /* x; y */ from t;;", []string{"select `a;b` /* x; y */ from t;"}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, SplitStatements(tt.body), "should be equal")
+	}
+}
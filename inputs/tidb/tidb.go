@@ -0,0 +1,134 @@
+// Package tidb reads TiDB slow query logs
+package tidb
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs/slowlog"
+)
+
+// tidbSkippedAttributes holds attribute lines whose values are not key/value
+// pairs (encoded plans, previous statement text)
+var tidbSkippedAttributes = []string{"# Plan:", "# Binary_plan:", "# Prev_stmt:"}
+
+// Reader reads TiDB slow logs
+type Reader struct{}
+
+func init() {
+	inputs.Add("tidb", Reader{})
+}
+
+// Sniff recognizes TiDB slow logs, whose entries start with a transaction
+// timestamp
+func (Reader) Sniff(head []byte) int {
+	if bytes.Contains(head, []byte("\n# Txn_start_ts: ")) {
+		return inputs.Certain
+	}
+	return 0
+}
+
+// Read reads a TiDB slow query log and sends raw queries to workers
+// TiDB writes one attribute per line, so entries are parsed here instead of
+// being sent to workers
+func (Reader) Read(r io.Reader, src *inputs.Source, events chan<- inputs.Query) error {
+	source := src.Name
+
+	// Lines longer than inputs.MaxLineSize are truncated & their entry is
+	// counted as truncated
+	scanner, splitter := inputs.NewLineScanner(r)
+
+	var (
+		qry       *inputs.Query
+		truncated bool // current entry has a truncated line
+	)
+
+	read := 0
+	skipped := 0
+	truncatedEntries := 0
+
+	// flush sends the current entry, if any
+	flush := func() {
+		if qry != nil && truncated {
+			truncatedEntries++
+		}
+		truncated = false
+
+		if qry == nil || qry.FullQuery == "" {
+			qry = nil
+			return
+		}
+		events <- *qry
+		qry = nil
+	}
+
+lines:
+	for scanner.Scan() {
+		line := scanner.Text()
+		read++
+
+		if strings.HasPrefix(line, "# Time:") {
+			flush()
+
+			qry = &inputs.Query{Source: source}
+
+			// # Time: 2019-08-14T09:26:59.487776265+08:00
+			t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(strings.TrimPrefix(line, "# Time:")))
+			if err != nil {
+				log.Warnf("unable to parse time '%s' at line %d: %v", line, read, err)
+			}
+			qry.Time = t
+			continue
+		}
+
+		if qry == nil {
+			skipped++
+			continue
+		}
+
+		if splitter.Truncated {
+			log.Debugf("line %d exceeds %d bytes; truncating", read, inputs.MaxLineSize)
+			truncated = true
+		}
+
+		for _, prefix := range tidbSkippedAttributes {
+			if strings.HasPrefix(line, prefix) {
+				continue lines
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(line, "# User@Host:"):
+			slowlog.ParseUserHost(line, qry)
+		case strings.HasPrefix(line, "#"):
+			slowlog.ParseAttributes(line, qry)
+		case strings.HasPrefix(strings.ToLower(line), "use ") && qry.FullQuery == "":
+			// "use test;" is written before the query when DB is set
+			if qry.Schema == "" {
+				qry.Schema = strings.Trim(strings.TrimSpace(line[4:]), "`;")
+			}
+		case line == "":
+		default:
+			// Multiline queries are kept verbatim
+			if qry.FullQuery != "" {
+				qry.FullQuery += "\n"
+			}
+			qry.FullQuery += line
+		}
+	}
+
+	flush()
+
+	src.Lines += read
+	src.SkippedLines += skipped
+	src.TruncatedEntries += truncatedEntries
+	if truncatedEntries > 0 {
+		log.Warnf("truncated %d entries with lines longer than %d bytes in %s", truncatedEntries, inputs.MaxLineSize, source)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,76 @@
+package tidb
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+)
+
+func TestReadTiDBLog(t *testing.T) {
+	tidblog := `# Time: 2019-08-14T09:26:59.487776265+08:00
+# Txn_start_ts: 410450924122144769
+# User@Host: root[root] @ localhost [127.0.0.1]
+# Conn_ID: 3086
+# Query_time: 1.527627037
+# Parse_time: 0.000054933
+# Compile_time: 0.000129729
+# Cop_time: 0.08 Process_time: 0.07 Wait_time: 0.01 Backoff_time: 0.002 Request_count: 1 Total_keys: 131073 Process_keys: 131072
+# DB: test
+# Is_internal: false
+# Digest: 50a2e32d2abbd6c1764b1b7f2058d428ef2712b029282b776beb9506a365c0f1
+# Stats: t:pseudo
+# Num_cop_tasks: 1
+# Cop_proc_avg: 0.07 Cop_proc_p90: 0.07 Cop_proc_max: 0.07 Cop_proc_addr: 172.16.5.87:20171
+# Mem_max: 525211
+# Disk_max: 65536
+# Succ: true
+# Plan: tidb_decode_plan('ZJAwCTMyXzcJMAkyMAlkYXRhOlRhYmxlU2Nhbl82CjEJMTJfNgkxAzAdb2JqZWN0X2lkLCB0YWJsZTp0MQ==')
+# Plan_digest: e5f9d9746c756438a13c75ba3eedf601eecf555cdb7ad327d7092bdd041a83e7
+# Prev_stmt: select 1: 2;
+use test;
+insert into t select * from t where id = 3;
+# Time: 2019-08-14T09:27:00.000000000+08:00
+# Query_time: 0.5
+# Succ: false
+# Mem_max: 1024
+select * from t
+where id = 4;
+`
+	src := &inputs.Source{Name: "test"}
+	events := make(chan inputs.Query, 10)
+
+	assert.Nil(t, Reader{}.Read(strings.NewReader(tidblog), src, events))
+	close(events)
+
+	var got []inputs.Query
+	for qry := range events {
+		got = append(got, qry)
+	}
+
+	if !assert.Len(t, got, 2) {
+		return
+	}
+
+	assert.Equal(t, "insert into t select * from t where id = 3;", got[0].FullQuery, "should be equal")
+	assert.Equal(t, "test", got[0].Schema, "should be equal")
+	assert.Equal(t, "root", got[0].User, "should be equal")
+	assert.Equal(t, 3086, got[0].ConnectionID, "should be equal")
+	assert.Equal(t, 1.527627037, got[0].QueryTime, "should be equal")
+	assert.Equal(t, "2019-08-14T01:26:59Z", got[0].Time.UTC().Format(time.RFC3339), "should be equal")
+	assert.True(t, got[0].HasTiDB)
+	assert.Equal(t, 0.000054933, got[0].ParseTime, "should be equal")
+	assert.Equal(t, 0.08, got[0].CopTime, "should be equal")
+	assert.Equal(t, 0.002, got[0].BackoffTime, "should be equal")
+	assert.Equal(t, 131072, got[0].ProcessKeys, "should be equal")
+	assert.Equal(t, 131073, got[0].TotalKeys, "should be equal")
+	assert.Equal(t, 1, got[0].CopTasks, "should be equal")
+	assert.Equal(t, 525211, got[0].MemMax, "should be equal")
+	assert.Equal(t, 65536, got[0].DiskMax, "should be equal")
+	assert.Equal(t, 0, got[0].LastErrno, "should be equal")
+
+	assert.Equal(t, "select * from t\nwhere id = 4;", got[1].FullQuery, "should be equal")
+	assert.Equal(t, 1, got[1].LastErrno, "should be equal")
+}
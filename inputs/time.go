@@ -0,0 +1,56 @@
+package inputs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeLayouts holds MySQL, Percona Server, MariaDB & PostgreSQL log
+// timestamps layouts
+// Fractional seconds are accepted after seconds in all of them
+var timeLayouts = []string{
+	time.RFC3339Nano,      // MySQL 5.7+: 2018-12-17T15:18:58.744913Z (or +01:00 with log_timestamps=SYSTEM)
+	"060102 15:04:05",     // MySQL 5.5/5.6, Percona Server, MariaDB: 190603 23:14:02 or 190603  3:14:02
+	"2006-01-02 15:04:05", // MariaDB 10.x: 2019-06-03 23:14:02
+	"2006-01-02T15:04:05", // without timezone
+	"20060102 15:04:05",   // MariaDB server_audit: 20200226 14:46:34
+
+	"2006-01-02T15:04:05 MST",    // MySQL Enterprise audit XML: 2019-10-03T14:06:33 UTC
+	"2006-01-02 15:04:05 -07:00", // PostgreSQL: 2019-06-03 23:14:02.123 +02:00
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07",
+	"2006-01-02 15:04:05 MST", // PostgreSQL: 2019-06-03 23:14:02.123 CEST
+}
+
+// ParseTime parses a log timestamp
+// Timestamps without timezone (MySQL 5.5/5.6, MariaDB) are in server local
+// time, which is loc (UTC when nil); zone abbreviations other than UTC & GMT
+// must be those of loc
+func ParseTime(s string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	// Hours are space padded: "190603  3:14:02"
+	s = strings.Join(strings.Fields(s), " ")
+
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, s, loc)
+		if err != nil {
+			continue
+		}
+
+		// Unknown zone abbreviations are parsed with a zero offset; only
+		// UTC, GMT & those of loc are known (e.g. CEST with Europe/Paris)
+		if strings.HasSuffix(layout, "MST") && t.Location() != loc {
+			if name, offset := t.Zone(); offset == 0 && name != "UTC" && name != "GMT" {
+				return time.Time{}, fmt.Errorf("unknown timezone abbreviation '%s' (see --timezone)", name)
+			}
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("unknown timestamp format '%s'", s)
+}
@@ -0,0 +1,52 @@
+package inputs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+
+	var tests = []struct {
+		in   string
+		loc  *time.Location
+		want string
+	}{
+		{" 2018-12-17T15:18:58.744913Z", nil, "2018-12-17T15:18:58.744913Z"},
+		{"2018-12-17T16:18:58.744913+01:00", paris, "2018-12-17T15:18:58.744913Z"},
+		{" 190603 23:14:02", nil, "2019-06-03T23:14:02Z"},
+		{" 190603  3:14:02", nil, "2019-06-03T03:14:02Z"},
+		{"190603  3:14:02", paris, "2019-06-03T01:14:02Z"},
+		{"130601  8:01:06.058915", nil, "2013-06-01T08:01:06.058915Z"},
+		{"2019-06-03 23:14:02", nil, "2019-06-03T23:14:02Z"},
+		{"2019-06-03 23:14:02", paris, "2019-06-03T21:14:02Z"},
+		{"20200226 14:46:34", paris, "2020-02-26T13:46:34Z"},
+		{"2019-10-03T14:06:33 UTC", paris, "2019-10-03T14:06:33Z"},
+		{"2021-03-01 10:00:00.123 +02:00", nil, "2021-03-01T08:00:00.123Z"},
+		{"2021-03-01 10:00:00.123 +0200", nil, "2021-03-01T08:00:00.123Z"},
+		{"2021-03-01 10:00:00 -03", nil, "2021-03-01T13:00:00Z"},
+		{"2021-03-01 10:00:00.123 UTC", paris, "2021-03-01T10:00:00.123Z"},
+		{"2019-06-03 23:14:02.123 CEST", paris, "2019-06-03T21:14:02.123Z"},
+		{"2019-12-03 23:14:02 CET", paris, "2019-12-03T22:14:02Z"},
+		{"2019-06-03 23:14:02 GMT", paris, "2019-06-03T23:14:02Z"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTime(tt.in, tt.loc)
+		assert.Nil(t, err, tt.in)
+		assert.Equal(t, tt.want, got.UTC().Format(time.RFC3339Nano), tt.in)
+	}
+
+	_, err = ParseTime("# Time: 190603 23:14:02", nil)
+	assert.NotNil(t, err)
+
+	// Abbreviations unknown in --timezone can not be resolved
+	_, err = ParseTime("2019-06-03 23:14:02.123 CEST", nil)
+	assert.NotNil(t, err)
+}
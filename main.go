@@ -1,14 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"crypto/sha256"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"regexp"
 	"runtime"
@@ -20,39 +19,16 @@ import (
 
 	"github.com/nxadm/tail"
 	log "github.com/sirupsen/logrus"
+	"gitlab.com/devopsworks/tools/dw-query-digest/inputs"
+	_ "gitlab.com/devopsworks/tools/dw-query-digest/inputs/all"
 	"gitlab.com/devopsworks/tools/dw-query-digest/outputs"
 	_ "gitlab.com/devopsworks/tools/dw-query-digest/outputs/all"
+	"gonum.org/v1/gonum/stat"
 	"gopkg.in/cheggaaa/pb.v1"
 )
 
-// logentry holds a complete query entry from log file
-type logentry struct {
-	lines [9]string
-	pos   int
-}
-
 // query holds a single query with metrics
-// FIXME: Do some stats with QCHit
-type query struct {
-	Time         time.Time
-	User         string
-	AltUser      string
-	Client       string
-	ConnectionID int
-	Schema       string
-	LastErrno    int
-	QCHit        bool
-	Killed       int
-	QueryTime    float64
-	LockTime     float64
-	RowsSent     int
-	RowsExamined int
-	RowsAffected int
-	BytesSent    int
-	FullQuery    string
-	FingerPrint  string
-	Hash         [32]byte
-}
+type query = inputs.Query
 
 // replacements holds list of regexps we'll apply to queries for normalization
 type replacements struct {
@@ -62,23 +38,51 @@ type replacements struct {
 
 // options holds options we got in arguments
 type options struct {
-	ShowProgress bool
-	Debug        bool
-	Quiet        bool
-	Top          int
-	SortKey      string
-	SortReverse  bool
-	Output       string
-	ListOutputs  bool
-	DisableCache bool
-	FileName     string
-	Follow       bool
-	Refresh      int
+	ShowProgress    bool
+	Debug           bool
+	Quiet           bool
+	Top             int
+	SortKey         string
+	SortReverse     bool
+	Output          string
+	ListOutputs     bool
+	ListInputs      bool
+	DisableCache    bool
+	FileName        string
+	Follow          bool
+	Refresh         int
+	PerSource       bool
+	InputFormat     string
+	ContainerLog    string
+	GroupBy         string
+	Timezone        string
+	AdminCommands   bool
+	MultiStatements string
 }
 
 // actual global variables
 var regexeps []replacements
+
+// groupby holds query grouping keys (--group-by)
+var groupby []string
+
+// sourcestats holds per-source query counts & time ranges
+// It is only used from the aggregator goroutine
+var sourcestats = map[string]*outputs.SourceInfo{}
+
+// procstats holds stored procedures statistics, by qualified name
+// It is only used from the aggregator goroutine
+var procstats = map[string]*outputs.ProcedureStats{}
+
+// servermeta holds server & analysis information
+// Readers record sources, server headers & server wide information (e.g.
+// transactions) while the aggregator reports them, so those are guarded by
+// metamu
 var servermeta outputs.ServerInfo
+var metamu sync.Mutex
+
+// timezone holds the timezone of log timestamps without one (--timezone)
+var timezone = time.UTC
 
 // Config holds global
 var Config options
@@ -115,6 +119,11 @@ func init() {
 		// 3·   Strip comments.
 		{regexp.MustCompile(`(.*)/\*.*\*/(.*)`), "$1$2"},
 		{regexp.MustCompile(`(.*) --.*`), "$1"},
+		// Group CALL statements by procedure, whatever the arguments
+		// Arguments stop at the closing parenthesis (quoted strings & one
+		// level of nested calls aside), so multi-statement bodies keep each
+		// statement
+		{regexp.MustCompile(`(^|;\s*)call\s+([^\s(;]+)\s*(\((?:[^()'";]|'[^']*'|"[^"]*"|\([^()]*\))*\))?`), "${1}call $2(?)"},
 		// 2·   Shorten multi-value INSERT statements to a single VALUES() list.
 		{regexp.MustCompile(`^(insert .*) values.*`), "$1 values (?)"},
 		// 4·   Abstract the databases in USE statements
@@ -149,12 +158,20 @@ func main() {
 	flag.BoolVar(&Config.Quiet, "quiet", false, "Display only the report")
 	flag.IntVar(&Config.Top, "top", 20, "Top queries to display")
 	flag.IntVar(&Config.Refresh, "refresh", 0, "How often to refresh display (ms)")
-	flag.StringVar(&Config.SortKey, "sort", "time", "Sort key (time (default), count, bytes, lock[time], [rows]sent, [rows]examined, [rows]affected")
+	flag.StringVar(&Config.SortKey, "sort", "time", "Sort key (time (default), count, bytes, lock[time], [rows]sent, [rows]examined, [rows]affected, [bytes]received, readfirst, readlast, readkey, readnext, readprev, readrnd, readrndnext, sortrows, sortrange, sortscan, [sort]mergepasses, tmptables, tmpdisktables, parsetime, compiletime, coptime, processtime, backofftime, processkeys, totalkeys, mem[max])")
 	flag.BoolVar(&Config.SortReverse, "reverse", false, "Reverse sort (lowest first)")
 	flag.StringVar(&Config.Output, "output", "terminal", "Report output (see `--list-outputs` for a list of possible outputs")
 	flag.BoolVar(&Config.ListOutputs, "list-outputs", false, "List possible outputs")
+	flag.BoolVar(&Config.ListInputs, "list-inputs", false, "List possible input formats")
 	flag.BoolVar(&Config.DisableCache, "nocache", false, "Disable cache usage (reading from and writing to)")
 	flag.BoolVar(&Config.Follow, "follow", false, "Follow file as it grows (tail -F style)")
+	flag.BoolVar(&Config.PerSource, "per-source", false, "Show per-source breakdown for each query")
+	flag.BoolVar(&Config.AdminCommands, "admin-commands", true, "Report administrator commands (Quit, Binlog Dump, ...; use --admin-commands=false to ignore them)")
+	flag.StringVar(&Config.InputFormat, "input-format", "auto", fmt.Sprintf("Input format (auto (default), %s)", strings.Join(inputs.Names(), ", ")))
+	flag.StringVar(&Config.GroupBy, "group-by", "fingerprint", "Comma separated query grouping keys (fingerprint (default), digest, hostgroup, backend)")
+	flag.StringVar(&Config.MultiStatements, "multi-statements", inputs.StatementsCompound, fmt.Sprintf("How to report slow log entries holding several statements (%s)", strings.Join(inputs.StatementsModes, ", ")))
+	flag.StringVar(&Config.Timezone, "timezone", "UTC", "Timezone of log timestamps without one (e.g. Local, Europe/Paris; MySQL 5.5/5.6, MariaDB, log_timestamps=SYSTEM)")
+	flag.StringVar(&Config.ContainerLog, "container-log", "auto", "Container log envelope to strip (auto (default), none, docker, cri, journald)")
 
 	var showversion = flag.Bool("version", false, "Show version & exit")
 
@@ -180,6 +197,34 @@ func main() {
 		os.Exit(1)
 	}
 
+	groupby = strings.Split(Config.GroupBy, ",")
+	if err := checkGroupBy(groupby); err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	tz, err := time.LoadLocation(Config.Timezone)
+	if err != nil {
+		log.Errorf("invalid timezone %s: %v", Config.Timezone, err)
+		os.Exit(1)
+	}
+	timezone = tz
+
+	if err := checkMultiStatements(Config.MultiStatements); err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	if err := checkContainerLogFormat(Config.ContainerLog); err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+
+	if err := checkInputFormat(Config.InputFormat); err != nil {
+		log.Errorf("%v", err)
+		os.Exit(1)
+	}
+
 	if Config.ListOutputs {
 		fmt.Println("Compiled outputs:")
 
@@ -189,16 +234,39 @@ func main() {
 		os.Exit(0)
 	}
 
+	if Config.ListInputs {
+		fmt.Println("Compiled inputs:")
+
+		for _, k := range inputs.Names() {
+			fmt.Printf("\t%s\n", k)
+		}
+		os.Exit(0)
+	}
+
 	// File selection
 	var (
 		file  *os.File
 		piper *io.PipeReader
 		pipew *io.PipeWriter
-		err   error
 	)
-	Config.FileName = flag.Arg(0)
 
-	if Config.FileName == "" || Config.FileName == "-" {
+	files, err := expandSources(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(files) > 0 {
+		Config.FileName = files[0]
+	}
+
+	if len(files) > 1 {
+		log.Infof(`reading %d files`, len(files))
+		if Config.Follow {
+			log.Fatal(`follow is not supported with multiple files`)
+		}
+		// Cache is tied to a single file
+		Config.DisableCache = true
+	} else if Config.FileName == "" || Config.FileName == "-" {
 		log.Info(`reading from STDIN`)
 		Config.FileName = ""
 		Config.DisableCache = true
@@ -210,6 +278,14 @@ func main() {
 		Config.ShowProgress = false
 		Config.DisableCache = true
 
+		if compression, _ := fileCompression(Config.FileName); compression != "" {
+			log.Fatalf(`unable to follow %s compressed file "%s"`, compression, Config.FileName)
+		}
+
+		if Config.InputFormat != "auto" && !inputs.CanFollow(Config.InputFormat) {
+			log.Fatalf(`follow is not supported with %s input format`, Config.InputFormat)
+		}
+
 		piper, pipew = io.Pipe()
 
 		go func(*io.PipeWriter) {
@@ -229,13 +305,6 @@ func main() {
 				}
 			}
 		}(pipew)
-	} else {
-		log.Infof(`using "%s" as input file`, Config.FileName)
-		file, err = os.Open(Config.FileName)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer file.Close()
 	}
 
 	log.Infof(`using "%s" output`, Config.Output)
@@ -243,7 +312,7 @@ func main() {
 	// If cache is not disabled and we're are not tailing input
 	// Try to display from cache
 	// If it succeeds, we've done our job
-	if !Config.DisableCache && runFromCache(flag.Arg(0)) {
+	if !Config.DisableCache && runFromCache(Config.FileName) {
 		log.Info(`results retrieved from cache`)
 		os.Exit(0)
 	}
@@ -255,7 +324,7 @@ func main() {
 
 	// Create channels
 	// closed by filereader
-	logentries := make(chan logentry, 1000)
+	events := make(chan query, 1000)
 
 	// closed by us
 	queries := make(chan query, 1000)
@@ -268,25 +337,21 @@ func main() {
 
 	wg.Add(1)
 
-	if Config.FileName == "" {
-		go fileReader(&wg, file, logentries, 0)
-	} else if Config.Follow {
-		go fileReader(&wg, piper, logentries, 0)
+	if len(files) > 1 || (Config.FileName != "" && !Config.Follow) {
+		go filesReader(&wg, Config.InputFormat, files, events)
+	} else if Config.Follow && Config.FileName != "" {
+		go fileReader(&wg, Config.InputFormat, piper, Config.FileName, events)
 	} else {
-		count, err := lineCounter(file)
+		input, compression, err := decompress(file)
 		if err != nil {
-			panic(err)
+			log.Fatal(err)
 		}
-
-		_, err = file.Seek(0, 0)
-		if err != nil {
-			panic(err)
+		defer input.Close()
+		if compression != "" {
+			log.Infof("reading %s compressed input", compression)
 		}
 
-		servermeta.CumLines = count
-		log.Infof("file has %d lines\n", count)
-
-		go fileReader(&wg, file, logentries, count)
+		go fileReader(&wg, Config.InputFormat, input, "stdin", events)
 	}
 
 	servermeta.AnalysisStart = time.Now()
@@ -299,7 +364,7 @@ func main() {
 
 	wg.Add(numWorkers)
 	for i := 0; i < numWorkers; i++ {
-		go worker(&wg, logentries, queries)
+		go worker(&wg, events, queries)
 	}
 
 	wg.Wait()
@@ -311,252 +376,197 @@ func main() {
 	<-done
 }
 
-// lineCouter counts number of lines in file
-func lineCounter(r io.Reader) (int, error) {
-	buf := make([]byte, 32*1024)
-	count := 0
-	lineSep := []byte{'\n'}
-
-	for {
-		c, err := r.Read(buf)
-		count += bytes.Count(buf[:c], lineSep)
-
-		switch {
-		case err == io.EOF:
-			return count, nil
-
-		case err != nil:
-			return count, err
-		}
-	}
-}
-
-func parseHeader(scanner *bufio.Scanner, meta *outputs.ServerInfo) error {
-	// Read version
-	scanner.Scan()
-	version := scanner.Text()
-
-	// Read listeners
-	scanner.Scan()
-	listeners := scanner.Text()
-
-	// Skip header line
-	scanner.Scan()
+func addSegment(meta *outputs.ServerInfo, seg outputs.ServerSegment) {
+	meta.Segments = append(meta.Segments, seg)
 
-	// Parse server information
-	versionre := regexp.MustCompile(`^([^,]+),\s+Version:\s+([0-9\.]+)([A-Za-z0-9-]+)\s+\((.*)\)\. started`)
-	matches := versionre.FindStringSubmatch(version)
-
-	if len(matches) != 5 {
-		meta.Binary = "unable to parse line"
-		meta.VersionShort = meta.Binary
-		meta.Version = meta.Binary
-		meta.VersionDescription = meta.Binary
-		meta.TCPPort = 0
-		meta.UnixSocket = meta.Binary
-		return fmt.Errorf("unable to parse server information; beginning of log might be missing")
+	if len(meta.Segments) == 1 {
+		meta.Binary = seg.Binary
+		meta.VersionShort = seg.VersionShort
+		meta.Version = seg.Version
+		meta.VersionDescription = seg.VersionDescription
+		meta.TCPPort = seg.TCPPort
+		meta.UnixSocket = seg.UnixSocket
 	}
-
-	meta.Binary = matches[1]
-	meta.VersionShort = matches[2]
-	meta.Version = meta.VersionShort + matches[3]
-	meta.VersionDescription = matches[4]
-	meta.TCPPort, _ = strconv.Atoi(strings.Split(listeners, " ")[2])
-	meta.UnixSocket = strings.TrimLeft(strings.Split(listeners, ":")[2], " ")
-
-	return nil
 }
 
-// fileReader reads slow log and adds queries in channel for workers
-func fileReader(wg *sync.WaitGroup, r io.Reader, lines chan<- logentry, count int) {
+// filesReader reads log files in order using format reader
+// With "auto" format, format is detected for each file
+func filesReader(wg *sync.WaitGroup, format string, files []string, events chan<- query) {
 	defer wg.Done()
-	defer close(lines)
-
-	scanner := bufio.NewScanner(r)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-
-	err := parseHeader(scanner, &servermeta)
-	if err != nil {
-		log.Errorf("error reading log header: %v", err)
-	}
-
-	// The entry we'll fill
-	curentry := logentry{}
-
-	// Fetch first "# Time" line
-	scanner.Scan()
-	line := scanner.Text()
-	for !strings.HasPrefix(line, "# Time") {
-		if !scanner.Scan() {
-			log.Errorf("unable to find initial '# Time' entry")
-			os.Exit(1)
-		}
-		line = scanner.Text()
-	}
-	curentry.lines[0] = line
+	defer close(events)
 
 	var bar *pb.ProgressBar
-
-	// Create progressbar
 	if Config.ShowProgress {
-		bar = pb.New(count)
-		bar.ShowSpeed = true
-		bar.Start()
+		bar = newProgressBar(files)
+		defer bar.Finish()
 	}
 
-	read := 0
-	curline := 0
-	foldnext := false
-
-	for scanner.Scan() {
-		line = scanner.Text()
-		read++
-		if Config.ShowProgress {
-			bar.Increment()
-		}
+	for _, name := range files {
+		log.Infof(`using "%s" as input file`, name)
 
-		// If we have `# Time`, send current entry and wipe clean and go on
-		if strings.HasPrefix(line, "# Time") {
-			lines <- curentry
-			curline = -1
-			for i := range curentry.lines {
-				curentry.lines[i] = ""
-			}
+		file, err := os.Open(name)
+		if err != nil {
+			log.Errorf("unable to open %s: %v", name, err)
+			continue
 		}
 
-		// Skip duplicated header
-		// FIXME: this does not match "/usr/libexec/mysqld" (cf https://gitlab.com/devopsworks/tools/dw-query-digest/issues/3)
-		firstword := strings.Split(line, " ")[0]
-		if firstword == "mysqld," || firstword == "Tcp" || firstword == "Time" {
+		r, err := prepareFile(file, bar)
+		if err != nil {
+			log.Errorf("unable to read %s: %v", name, err)
+			file.Close()
 			continue
 		}
 
-		// We check that line number is below capacity minus one
-		// Why minus one ? because we increment curline and use it as an index
-		// inside this if
-		if curline < cap(curentry.lines)-1 {
-			// Now if line does not end with a ';', this is a multiline query
-			// So we append to previous entry in slice
-			if foldnext {
-				curentry.lines[curline] = strings.Join([]string{curentry.lines[curline], line}, " ")
-			} else {
-				curline++
-				log.Debugf("curline is %d, len is %d, cap is %d)\n", curline, len(curentry.lines), cap(curentry.lines))
-				curentry.lines[curline] = line
-			}
-
-			foldnext = false
-			firstchar := curentry.lines[curline][:1]
-			lastchar := curentry.lines[curline][len(curentry.lines[curline])-1:]
+		readInput(format, r, name, events)
+		r.Close()
+		file.Close()
+	}
+}
 
-			if lastchar != ";" && firstchar != "#" {
-				log.Debugf("line (%d) will fold after %s\n", read+1, firstword)
-				foldnext = true
-			}
-		} else {
-			log.Warningf(`request to add element %d for line "%s" exceeds capacity`, curline, line)
+// newProgressBar returns a started progress bar sized to the total of files
+func newProgressBar(files []string) *pb.ProgressBar {
+	var total int64
+	for _, name := range files {
+		if fi, err := os.Stat(name); err == nil {
+			total += fi.Size()
 		}
 	}
 
-	// Ship the last curentry
-	lines <- curentry
+	bar := pb.New64(total).SetUnits(pb.U_BYTES)
+	bar.ShowSpeed = true
+	bar.Start()
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	return bar
+}
+
+// prepareFile returns a reader for file content, to be closed once read
+// Compressed files are decompressed on the fly; progress, when bar is not
+// nil, is based on file bytes consumed, so it works the same for compressed &
+// plain files
+func prepareFile(file *os.File, bar *pb.ProgressBar) (io.ReadCloser, error) {
+	var input io.Reader = file
+	if bar != nil {
+		input = bar.NewProxyReader(file)
 	}
+
+	r, compression, err := decompress(input)
+	if compression != "" {
+		log.Infof("reading %s compressed file", compression)
+	}
+
+	return r, err
 }
 
-// worker reads queries entries from a channel, parses them to create a clean
-// query{} structure, so aggregator can directly extract stats
-func worker(wg *sync.WaitGroup, lines <-chan logentry, entries chan<- query) {
+// fileReader reads a single log using format reader
+func fileReader(wg *sync.WaitGroup, format string, r io.Reader, source string, events chan<- query) {
 	defer wg.Done()
+	defer close(events)
 
-	var err error
-	var smallbuf string
+	readInput(format, r, source, events)
+}
 
-	for lineblock := range lines {
-		qry := query{}
-		for _, line := range lineblock.lines {
-			if line == "" {
-				break
-			}
+// readInput reads source content in r using format reader & records it in
+// servermeta
+// Source is recorded before being read so server headers are reported while
+// following a file
+func readInput(format string, r io.Reader, source string, events chan<- query) {
+	unwrapped := unwrapContainerLog(r, Config.ContainerLog, source)
+	defer unwrapped.Close()
+
+	reader, r := sniffReader(unwrapped, format, source)
+
+	metamu.Lock()
+	idx := len(servermeta.Sources)
+	servermeta.Sources = append(servermeta.Sources, outputs.SourceInfo{Name: source})
+	metamu.Unlock()
+
+	src := &inputs.Source{
+		Name:       source,
+		Location:   timezone,
+		Statements: Config.MultiStatements,
+	}
+	src.OnSegment = func(seg outputs.ServerSegment) {
+		metamu.Lock()
+		addSourceSegment(idx, seg)
+		metamu.Unlock()
+	}
+	src.OnServerInfo = func(update func(*outputs.ServerInfo)) {
+		metamu.Lock()
+		update(&servermeta)
+		metamu.Unlock()
+	}
 
-			if len(line) < 5 {
-				log.Warnf("unable to parse line preamble for '%s'; skipping", line)
-				continue
+	if err := reader.Read(r, src, events); err != nil {
+		log.Errorf("error reading %s: %v", source, err)
+	}
+	log.Infof("read %d lines from %s", src.Lines, source)
+
+	metamu.Lock()
+	defer metamu.Unlock()
+
+	// Sources found inside this one are reported instead
+	if len(src.Parts) > 0 {
+		servermeta.Sources = servermeta.Sources[:idx]
+		for _, part := range src.Parts {
+			idx := len(servermeta.Sources)
+			servermeta.Sources = append(servermeta.Sources, outputs.SourceInfo{Name: part.Name})
+			for _, seg := range part.Segments {
+				addSourceSegment(idx, seg)
 			}
+			recordSource(idx, part)
+		}
+		return
+	}
 
-			switch strings.ToUpper(line[0:4]) {
-			case "# TI":
-				// # Time: 2018-12-17T15:18:58.744913Z
-				// or
-				// # Time: 190603 23:14:02 // in mariadb
-				qry.Time, err = time.Parse(time.RFC3339, strings.Split(line, " ")[2])
-				if err != nil {
-					splitted := strings.Split(line, " ")
-					if len(splitted) > 2 {
-						datetime := strings.Join(splitted, " ")
-						qry.Time, err = time.Parse("060102 15:04:05", datetime)
-						if err != nil {
-							log.Errorf("worker: error parsing time '%s': %v", datetime, err)
-						}
-					} else {
-						log.Errorf("worker: error parsing time in line '%s'", line)
-					}
-				}
-
-			case "# US":
-				// # User@Host: agency[agency] @  [192.168.0.102]  Id: 3502988
-				s := strings.Replace(line, "[", " ", -1)
-				s = strings.Replace(s, "]", " ", -1)
-				fmt.Sscanf(s, "# User@Host: %s %s  @   %s   Id: %d", &qry.AltUser, &qry.User, &qry.Client, &qry.ConnectionID)
-
-			case "# SC": // "#S"
-				//# Schema: taskl-production  Last_errno: 0  Killed: 0
-				fmt.Sscanf(line, "# Schema: %s  Last_errno: %d  Killed: %d", &qry.Schema, &qry.LastErrno, &qry.Killed)
-
-			case "# TH": // "#S"
-				//# Thread_id: 3  Schema: thedb  QC_hit: No
-				fmt.Sscanf(line, "# Thread_id: %d Schema: %s QC_hit: %s", &qry.Schema, &qry.LastErrno, &smallbuf)
-				if smallbuf != "No" {
-					qry.QCHit = true
-				}
+	recordSource(idx, src)
+}
 
-			case "# QU": // "#Q"
-				// # Query_time: 0.000030  Lock_time: 0.000000  Rows_sent: 0  Rows_examined: 0  Rows_affected: 0
-				fmt.Sscanf(line, "# Query_time: %f  Lock_time: %f  Rows_sent: %d  Rows_examined: %d  Rows_affected: %d",
-					&qry.QueryTime, &qry.LockTime, &qry.RowsSent, &qry.RowsExamined, &qry.RowsAffected)
+// addSourceSegment records a server header found in servermeta.Sources[idx]
+// metamu must be held
+func addSourceSegment(idx int, seg outputs.ServerSegment) {
+	addSegment(&servermeta, seg)
+	if servermeta.Sources[idx].Version == "" {
+		servermeta.Sources[idx].Binary = seg.Binary
+		servermeta.Sources[idx].Version = seg.Version
+	}
+}
 
-			case "# BY":
-				// # Bytes_sent: 561
-				fmt.Sscanf(line, "# Bytes_sent: %d", &qry.BytesSent)
+// recordSource records src lines & entries counts in servermeta.Sources[idx]
+// metamu must be held
+func recordSource(idx int, src *inputs.Source) {
+	servermeta.CumLines += src.Lines
+	servermeta.SkippedLines += src.SkippedLines
+	servermeta.TruncatedEntries += src.TruncatedEntries
+	servermeta.Sources[idx].Lines = src.Lines
+	servermeta.Sources[idx].SkippedLines = src.SkippedLines
+	servermeta.Sources[idx].TruncatedEntries = src.TruncatedEntries
+}
 
-			case "SET ":
-			case "USE ":
-			case "# AD":
-				continue
+// worker reads queries from readers, fingerprints them when needed & sends
+// them to aggregator
+func worker(wg *sync.WaitGroup, events <-chan query, queries chan<- query) {
+	defer wg.Done()
 
-			default:
-				qry.FullQuery = line
-				if qry.FullQuery == "" {
-					log.Warnf("worker: got empty query at line %d", lineblock.pos)
-				}
+	for qry := range events {
+		if qry.Admin && !Config.AdminCommands {
+			continue
+		}
 
-				// fmt.Printf("# call   : %s - %s\n", qry.FingerPrint, line)
+		if qry.FingerPrint == "" {
+			if qry.Normalize != nil {
+				qry.FingerPrint = qry.Normalize(qry.FullQuery)
+			} else {
 				fingerprint(&qry)
-				if qry.FingerPrint == "" {
-					log.Warnf("worker: got empty fingerprint after fingerprinting at line %d", lineblock.pos)
-				}
 			}
 		}
 
-		// We had no queries so we skip this logentries set
+		// We had no query so we skip this one
 		if qry.FingerPrint == "" {
+			log.Warnf("worker: got empty fingerprint after fingerprinting in %s", qry.Source)
 			continue
 		}
 		qry.Hash = sha256.Sum256([]byte(qry.FingerPrint))
-		entries <- qry
+		queries <- qry
 	}
 	log.Debug("worker exiting")
 }
@@ -571,10 +581,19 @@ func fingerprint(qry *query) {
 	// (since we do not need to be case insensitive when matching SQL keywords)
 	qry.FingerPrint = strings.ToLower(qry.FullQuery)
 
-	// Apply all regexps
-	for _, r := range regexeps {
-		qry.FingerPrint = r.Rexp.ReplaceAllString(qry.FingerPrint, r.Repl)
+	// Multiline queries are kept verbatim by readers; regexps expect a single
+	// line
+	if strings.Contains(qry.FingerPrint, "\n") {
+		qry.FingerPrint = strings.Replace(qry.FingerPrint, "\n", " ", -1)
 	}
+
+	// Apply all regexps, statement by statement
+	qry.FingerPrint = inputs.NormalizeStatements(qry.FingerPrint, func(stmt string) string {
+		for _, r := range regexeps {
+			stmt = r.Rexp.ReplaceAllString(stmt, r.Repl)
+		}
+		return stmt
+	})
 	log.Debugf("fingerprint normalized query to: %s", qry.FingerPrint)
 }
 
@@ -623,69 +642,491 @@ func aggregator(queries <-chan query, done chan<- bool, tickerdelay time.Duratio
 				return
 			}
 
-			servermeta.QueryCount++
+			// Time based metrics are unavailable unless at least one query has them
+			if servermeta.QueryCount == 0 || !qry.NoTiming {
+				servermeta.NoTiming = qry.NoTiming
+			}
+
+			// Queries aggregated by the server stand for several executions
+			calls := 1
+			if qry.Summary != nil {
+				calls = qry.Summary.Calls
+			}
+
+			servermeta.QueryCount += calls
 			servermeta.CumBytes += qry.BytesSent
-			if servermeta.Start.After(qry.Time) {
-				servermeta.Start = qry.Time
+
+			// Use exact statement start when we have it
+			start := qry.Time
+			if !qry.Start.IsZero() {
+				start = qry.Start
+			}
+
+			// Queries without time (e.g. before the first timestamp of a
+			// log) must not move the capture start to year 1
+			timed := !qry.Time.IsZero()
+
+			if timed && servermeta.Start.After(start) {
+				servermeta.Start = start
 			}
 			if servermeta.End.Before(qry.Time) {
 				servermeta.End = qry.Time
 			}
 
+			src, ok := sourcestats[qry.Source]
+			if !ok {
+				src = &outputs.SourceInfo{Start: start, End: qry.Time}
+				sourcestats[qry.Source] = src
+			}
+			src.QueryCount += calls
+			if timed && (src.Start.After(start) || src.Start.IsZero()) {
+				src.Start = start
+			}
+			if src.End.Before(qry.Time) {
+				src.End = qry.Time
+			}
+
+			addProcedureStats(&qry)
+
+			qry.Hash = groupHash(&qry, groupby)
+
 			if _, ok := querylist[qry.Hash]; !ok {
 				// New entry, create
 				querylist[qry.Hash] = &outputs.QueryStats{FingerPrint: qry.FingerPrint, Hash: qry.Hash}
 				querylist[qry.Hash].Schema = qry.Schema
+				querylist[qry.Hash].NoTiming = qry.NoTiming
+				querylist[qry.Hash].Digest = qry.Digest
+				querylist[qry.Hash].Routine = qry.Routine
+
+				// Only meaningful when queries are grouped by them
+				for _, key := range groupby {
+					switch key {
+					case "hostgroup":
+						querylist[qry.Hash].Hostgroup = qry.Hostgroup
+					case "backend":
+						querylist[qry.Hash].Backend = qry.Backend
+					}
+				}
+			}
+
+			qs := querylist[qry.Hash]
+
+			// Schema may be unknown for the first queries of a log
+			if qs.Schema == "" {
+				qs.Schema = qry.Schema
+			}
+
+			if !qry.NoTiming {
+				qs.NoTiming = false
+			}
+
+			if qry.Summary != nil {
+				addSummary(qs, &qry)
+				continue
 			}
 
 			if qry.LastErrno != 0 {
-				querylist[qry.Hash].CumErrored++
+				qs.CumErrored++
+			}
+
+			qs.Count++
+			qs.CumKilled += qry.Killed
+			qs.CumRowsSent += qry.RowsSent
+			qs.CumRowsExamined += qry.RowsExamined
+			qs.CumRowsAffected += qry.RowsAffected
+			qs.CumBytesSent += qry.BytesSent
+			qs.CumTmpTables += qry.TmpTables
+			qs.CumTmpDiskTables += qry.TmpDiskTables
+			qs.CumTmpTableSizes += qry.TmpTableSizes
+			qs.CumMergePasses += qry.MergePasses
+
+			// Queries without timing (e.g. general log) must not skew time
+			// distributions toward 0
+			if !qry.NoTiming {
+				qs.CumQueryTime += qry.QueryTime
+				qs.CumLockTime += qry.LockTime
+				qs.QueryTime = append(qs.QueryTime, qry.QueryTime)
+				qs.LockTime = append(qs.LockTime, qry.LockTime)
+			}
+
+			qs.BytesSent = append(qs.BytesSent, float64(qry.BytesSent))
+			qs.RowsSent = append(qs.RowsSent, float64(qry.RowsSent))
+			qs.RowsExamined = append(qs.RowsExamined, float64(qry.RowsExamined))
+			qs.RowsAffected = append(qs.RowsAffected, float64(qry.RowsAffected))
+
+			if Config.PerSource {
+				if qs.PerSource == nil {
+					qs.PerSource = make(map[string]*outputs.QuerySourceStats)
+				}
+				if _, ok := qs.PerSource[qry.Source]; !ok {
+					qs.PerSource[qry.Source] = &outputs.QuerySourceStats{}
+				}
+				qs.PerSource[qry.Source].Count++
+				qs.PerSource[qry.Source].CumQueryTime += qry.QueryTime
+			}
+
+			// Query plan booleans are counted so we can report percentages
+			if qry.HasQueryPlan {
+				qs.QueryPlanCount++
+				qs.CumQCHit += boolToInt(qry.QCHit)
+				qs.CumFullScan += boolToInt(qry.FullScan)
+				qs.CumFullJoin += boolToInt(qry.FullJoin)
+				qs.CumTmpTable += boolToInt(qry.TmpTable)
+				qs.CumTmpTableOnDisk += boolToInt(qry.TmpTableOnDisk)
+				qs.CumFilesort += boolToInt(qry.Filesort)
+				qs.CumFilesortOnDisk += boolToInt(qry.FilesortOnDisk)
+			}
+
+			if qry.HasInnoDB {
+				qs.InnoDBCount++
+				qs.CumInnoDBIOReadOps += qry.InnoDBIOReadOps
+				qs.CumInnoDBIOReadBytes += qry.InnoDBIOReadBytes
+				qs.CumInnoDBIOReadWait += qry.InnoDBIOReadWait
+				qs.CumInnoDBRecLockWait += qry.InnoDBRecLockWait
+				qs.CumInnoDBQueueWait += qry.InnoDBQueueWait
+				qs.CumInnoDBPagesDistinct += qry.InnoDBPagesDistinct
+
+				qs.InnoDBIOReadWait = append(qs.InnoDBIOReadWait, qry.InnoDBIOReadWait)
+				qs.InnoDBRecLockWait = append(qs.InnoDBRecLockWait, qry.InnoDBRecLockWait)
+				qs.InnoDBQueueWait = append(qs.InnoDBQueueWait, qry.InnoDBQueueWait)
+				qs.InnoDBPagesDistinct = append(qs.InnoDBPagesDistinct, float64(qry.InnoDBPagesDistinct))
+			}
+
+			// Keep the plan of the slowest sample
+			if len(qry.ExplainRows) > 0 && (qs.Explain == nil || qry.QueryTime > qs.Explain.QueryTime) {
+				qs.Explain = &outputs.Explain{
+					QueryTime: qry.QueryTime,
+					Query:     qry.FullQuery,
+					Columns:   qry.ExplainColumns,
+					Rows:      qry.ExplainRows,
+				}
+			}
+
+			if qry.HasSlowExtra {
+				qs.SlowExtraCount++
+				qs.CumBytesReceived += qry.BytesReceived
+				qs.CumReadFirst += qry.ReadFirst
+				qs.CumReadLast += qry.ReadLast
+				qs.CumReadKey += qry.ReadKey
+				qs.CumReadNext += qry.ReadNext
+				qs.CumReadPrev += qry.ReadPrev
+				qs.CumReadRnd += qry.ReadRnd
+				qs.CumReadRndNext += qry.ReadRndNext
+				qs.CumSortRangeCount += qry.SortRangeCount
+				qs.CumSortRows += qry.SortRows
+				qs.CumSortScanCount += qry.SortScanCount
+			}
+
+			if qry.HasTiDB {
+				qs.TiDBCount++
+				qs.CumParseTime += qry.ParseTime
+				qs.CumCompileTime += qry.CompileTime
+				qs.CumCopTime += qry.CopTime
+				qs.CumProcessTime += qry.ProcessTime
+				qs.CumWaitTime += qry.WaitTime
+				qs.CumBackoffTime += qry.BackoffTime
+				qs.CumProcessKeys += qry.ProcessKeys
+				qs.CumTotalKeys += qry.TotalKeys
+				qs.CumCopTasks += qry.CopTasks
+				qs.CumWriteKeys += qry.WriteKeys
+				qs.CumMemMax += qry.MemMax
+				if qry.MemMax > qs.MaxMemMax {
+					qs.MaxMemMax = qry.MemMax
+				}
+				if qry.DiskMax > qs.MaxDiskMax {
+					qs.MaxDiskMax = qry.DiskMax
+				}
+			}
+		}
+	}
+}
+
+// groupKeys holds possible query grouping keys
+var groupKeys = []string{"fingerprint", "digest", "hostgroup", "backend"}
+
+// checkGroupBy returns an error if a grouping key is unknown
+func checkGroupBy(keys []string) error {
+	for _, key := range keys {
+		known := false
+		for _, k := range groupKeys {
+			known = known || k == key
+		}
+		if !known {
+			return fmt.Errorf("unknown grouping key %s", key)
+		}
+	}
+	return nil
+}
+
+// groupHash returns the key used to aggregate qry
+// Queries without a server digest are grouped by fingerprint when grouping by
+// digest
+func groupHash(qry *query, keys []string) [32]byte {
+	if len(keys) == 0 || (len(keys) == 1 && keys[0] == "fingerprint") {
+		return qry.Hash
+	}
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		switch key {
+		case "fingerprint":
+			parts = append(parts, qry.FingerPrint)
+		case "digest":
+			if qry.Digest != "" {
+				parts = append(parts, "digest:"+qry.Digest)
+			} else {
+				parts = append(parts, qry.FingerPrint)
 			}
+		case "hostgroup":
+			parts = append(parts, qry.Hostgroup)
+		case "backend":
+			parts = append(parts, qry.Backend)
+		}
+	}
+
+	return sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+}
+
+// addSummary merges statistics aggregated by the server (qry.Summary) in qs
+// Summaries of the same fingerprint (e.g. in different schemas) keep
+// extremes, quantiles being approximated by the highest one
+func addSummary(qs *outputs.QueryStats, qry *query) {
+	sum := qry.Summary
+
+	if qs.QueryTimeSummary == nil {
+		qs.QueryTimeSummary = &outputs.QueryTimeSummary{Min: sum.Min}
+	}
+	ts := qs.QueryTimeSummary
+
+	qs.Count += sum.Calls
+	qs.CumErrored += sum.Errors
+	qs.CumQueryTime += qry.QueryTime
+	qs.CumLockTime += qry.LockTime
+	qs.CumRowsSent += qry.RowsSent
+	qs.CumRowsExamined += qry.RowsExamined
+	qs.CumRowsAffected += qry.RowsAffected
+	qs.CumTmpTables += qry.TmpTables
+	qs.CumTmpDiskTables += qry.TmpDiskTables
+	qs.CumMergePasses += qry.MergePasses
+	qs.CumSortRows += qry.SortRows
+	qs.CumSortRangeCount += qry.SortRangeCount
+	qs.CumSortScanCount += qry.SortScanCount
+
+	// Full scans & joins are reported as percentages of calls
+	if sum.HasQueryPlan {
+		qs.QueryPlanCount += sum.Calls
+		qs.CumFullScan += sum.FullScans
+		qs.CumFullJoin += sum.FullJoins
+	}
+
+	if sum.Min < ts.Min {
+		ts.Min = sum.Min
+	}
+	if sum.Max > ts.Max {
+		ts.Max = sum.Max
+	}
+	if sum.P95 > ts.P95 {
+		ts.P95 = sum.P95
+	}
+	if sum.P99 > ts.P99 {
+		ts.P99 = sum.P99
+	}
+	if sum.P999 > ts.P999 {
+		ts.P999 = sum.P999
+	}
+	if qs.Count > 0 {
+		ts.Mean = qs.CumQueryTime / float64(qs.Count)
+	}
+}
+
+// mergeSamples folds query times read from logs in the distribution
+// aggregated by the server, when qs has both, so reported statistics cover
+// all executions
+// Quantiles are approximated by the highest one, as when summaries are
+// merged, and only when the server provided them; the distribution only
+// widens, so it is safe to merge again as samples are added
+func mergeSamples(qs *outputs.QueryStats) {
+	ts := qs.QueryTimeSummary
+	if ts == nil || len(qs.QueryTime) == 0 {
+		return
+	}
+
+	samples := append([]float64(nil), qs.QueryTime...)
+	sort.Float64s(samples)
+
+	if samples[0] < ts.Min {
+		ts.Min = samples[0]
+	}
+	if max := samples[len(samples)-1]; max > ts.Max {
+		ts.Max = max
+	}
+
+	if ts.P95 > 0 {
+		ts.P95 = math.Max(ts.P95, stat.Quantile(0.95, 1, samples, nil))
+		ts.P99 = math.Max(ts.P99, stat.Quantile(0.99, 1, samples, nil))
+		ts.P999 = math.Max(ts.P999, stat.Quantile(0.999, 1, samples, nil))
+	}
+
+	if qs.Count > 0 {
+		ts.Mean = qs.CumQueryTime / float64(qs.Count)
+	}
+}
+
+// boolToInt returns 1 if b is true, 0 otherwise
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// percent returns n as a percentage of total
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100.0 * float64(n) / float64(total)
+}
+
+// procedureName returns the lowercased, schema qualified name of a
+// procedure from a routine name or a CALL fingerprint
+func procedureName(name, schema string) string {
+	name = strings.ToLower(strings.Replace(name, "`", "", -1))
+	name = strings.TrimPrefix(name, "call ")
+	if i := strings.IndexAny(name, "( ;"); i >= 0 {
+		name = name[:i]
+	}
+
+	if !strings.Contains(name, ".") && schema != "" {
+		name = strings.ToLower(schema) + "." + name
+	}
+
+	return name
+}
+
+// addProcedureStats accounts qry to its stored procedure, if any: either a
+// CALL, or a statement run by a procedure
+func addProcedureStats(qry *query) {
+	var name string
+	call := strings.HasPrefix(qry.FingerPrint, "call ")
+
+	switch {
+	case call:
+		name = procedureName(qry.FingerPrint, qry.Schema)
+	case qry.Routine != "":
+		name = procedureName(qry.Routine, qry.Schema)
+	default:
+		return
+	}
+
+	ps, ok := procstats[name]
+	if !ok {
+		ps = &outputs.ProcedureStats{Name: name}
+		procstats[name] = ps
+	}
+
+	if call {
+		ps.Calls++
+		ps.CumCallTime += qry.QueryTime
+	} else {
+		ps.Statements++
+		ps.CumStatementTime += qry.QueryTime
+	}
+}
+
+// procedureRanking returns procedures sorted by total time
+// CALL durations include the statements they ran, so statements time only
+// matters when calls are not logged (log_slow_sp_statements=OFF_NO_CALLS)
+func procedureRanking() []outputs.ProcedureStats {
+	procs := make([]outputs.ProcedureStats, 0, len(procstats))
+	for _, ps := range procstats {
+		p := *ps
+		p.CumTime = p.CumCallTime
+		if p.CumStatementTime > p.CumTime {
+			p.CumTime = p.CumStatementTime
+		}
+		procs = append(procs, p)
+	}
 
-			querylist[qry.Hash].Count++
-			querylist[qry.Hash].CumKilled += qry.Killed
-			querylist[qry.Hash].CumQueryTime += qry.QueryTime
-			querylist[qry.Hash].CumLockTime += qry.LockTime
-			querylist[qry.Hash].CumRowsSent += qry.RowsSent
-			querylist[qry.Hash].CumRowsExamined += qry.RowsExamined
-			querylist[qry.Hash].CumRowsAffected += qry.RowsAffected
-			querylist[qry.Hash].CumBytesSent += qry.BytesSent
-
-			querylist[qry.Hash].QueryTime = append(querylist[qry.Hash].QueryTime, qry.QueryTime)
-			querylist[qry.Hash].BytesSent = append(querylist[qry.Hash].BytesSent, float64(qry.BytesSent))
-			querylist[qry.Hash].LockTime = append(querylist[qry.Hash].LockTime, qry.LockTime)
-			querylist[qry.Hash].RowsSent = append(querylist[qry.Hash].RowsSent, float64(qry.RowsSent))
-			querylist[qry.Hash].RowsExamined = append(querylist[qry.Hash].RowsExamined, float64(qry.RowsExamined))
-			querylist[qry.Hash].RowsAffected = append(querylist[qry.Hash].RowsAffected, float64(qry.RowsAffected))
+	sort.Slice(procs, func(i, j int) bool {
+		if procs[i].CumTime == procs[j].CumTime {
+			return procs[i].Name < procs[j].Name
 		}
+		return procs[i].CumTime > procs[j].CumTime
+	})
+
+	return procs
+}
+
+// copyServerInfo returns a copy of info that shares no data with it
+func copyServerInfo(info outputs.ServerInfo) outputs.ServerInfo {
+	info.Sources = append([]outputs.SourceInfo(nil), info.Sources...)
+	info.Segments = append([]outputs.ServerSegment(nil), info.Segments...)
+	if info.Transactions != nil {
+		tx := *info.Transactions
+		info.Transactions = &tx
 	}
+	return info
 }
 
 // displayReport show a report given the select output
 func displayReport(querylist map[[32]byte]*outputs.QueryStats, sinfo *outputs.ServerInfo, final bool) {
+	var meta outputs.ServerInfo
+
 	if sinfo == nil {
+		metamu.Lock()
 		servermeta.UniqueQueries = len(querylist)
 		servermeta.AnalysisEnd = time.Now()
 		servermeta.AnalysisDuration = servermeta.AnalysisEnd.Sub(servermeta.AnalysisStart).Seconds()
 		servermeta.AnalysedLinesPerSecond = float64(servermeta.CumLines) / servermeta.AnalysisDuration
 		servermeta.AnalysedBytesPerSecond = float64(servermeta.CumBytes) / servermeta.AnalysisDuration
 		servermeta.AnalysedQueriesPerSecond = float64(servermeta.QueryCount) / servermeta.AnalysisDuration
+
+		for i, src := range servermeta.Sources {
+			if st, ok := sourcestats[src.Name]; ok {
+				servermeta.Sources[i].QueryCount = st.QueryCount
+				servermeta.Sources[i].Start = st.Start
+				servermeta.Sources[i].End = st.End
+			}
+		}
+
+		servermeta.Procedures = procedureRanking()
+
+		// Readers may still be running
+		meta = copyServerInfo(servermeta)
+		metamu.Unlock()
 	} else {
 		servermeta = *sinfo
+		meta = servermeta
 	}
 
 	s := make(outputs.QueryStatsSlice, 0, len(querylist))
 	for _, d := range querylist {
 		// TODO: make all the crappy calculations here so we do not have to repeat them in every output
+		d.QCHitPct = percent(d.CumQCHit, d.QueryPlanCount)
+		d.FullScanPct = percent(d.CumFullScan, d.QueryPlanCount)
+		d.FullJoinPct = percent(d.CumFullJoin, d.QueryPlanCount)
+		d.TmpTablePct = percent(d.CumTmpTable, d.QueryPlanCount)
+		d.TmpTableOnDiskPct = percent(d.CumTmpTableOnDisk, d.QueryPlanCount)
+		d.FilesortPct = percent(d.CumFilesort, d.QueryPlanCount)
+		d.FilesortOnDiskPct = percent(d.CumFilesortOnDisk, d.QueryPlanCount)
+		mergeSamples(d)
 		s = append(s, d)
 	}
 
 	// fmt.Printf("sortkey is %s\n", Config.SortKey)
 
+	sortkey := strings.ToUpper(Config.SortKey)
+
+	// Without timing information, sorting by time makes no sense
+	if meta.NoTiming && (sortkey == "TIME" || sortkey == "") {
+		sortkey = "COUNT"
+	}
+
 	sort.Slice(s, func(i, j int) bool {
 		var a, b float64
 
-		switch strings.ToUpper(Config.SortKey) {
+		switch sortkey {
 
 		case "COUNT":
 			a = float64(s[i].Count)
@@ -705,6 +1146,72 @@ func displayReport(querylist map[[32]byte]*outputs.QueryStats, sinfo *outputs.Se
 		case "ROWSAFFECTED", "AFFECTED":
 			a = float64(s[i].CumRowsAffected)
 			b = float64(s[j].CumRowsAffected)
+		case "BYTESRECEIVED", "RECEIVED":
+			a = float64(s[i].CumBytesReceived)
+			b = float64(s[j].CumBytesReceived)
+		case "READFIRST":
+			a = float64(s[i].CumReadFirst)
+			b = float64(s[j].CumReadFirst)
+		case "READLAST":
+			a = float64(s[i].CumReadLast)
+			b = float64(s[j].CumReadLast)
+		case "READKEY":
+			a = float64(s[i].CumReadKey)
+			b = float64(s[j].CumReadKey)
+		case "READNEXT":
+			a = float64(s[i].CumReadNext)
+			b = float64(s[j].CumReadNext)
+		case "READPREV":
+			a = float64(s[i].CumReadPrev)
+			b = float64(s[j].CumReadPrev)
+		case "READRND":
+			a = float64(s[i].CumReadRnd)
+			b = float64(s[j].CumReadRnd)
+		case "READRNDNEXT":
+			a = float64(s[i].CumReadRndNext)
+			b = float64(s[j].CumReadRndNext)
+		case "SORTROWS":
+			a = float64(s[i].CumSortRows)
+			b = float64(s[j].CumSortRows)
+		case "SORTRANGE":
+			a = float64(s[i].CumSortRangeCount)
+			b = float64(s[j].CumSortRangeCount)
+		case "SORTSCAN":
+			a = float64(s[i].CumSortScanCount)
+			b = float64(s[j].CumSortScanCount)
+		case "MERGEPASSES", "SORTMERGEPASSES":
+			a = float64(s[i].CumMergePasses)
+			b = float64(s[j].CumMergePasses)
+		case "TMPTABLES":
+			a = float64(s[i].CumTmpTables)
+			b = float64(s[j].CumTmpTables)
+		case "TMPDISKTABLES":
+			a = float64(s[i].CumTmpDiskTables)
+			b = float64(s[j].CumTmpDiskTables)
+		case "PARSETIME":
+			a = s[i].CumParseTime
+			b = s[j].CumParseTime
+		case "COMPILETIME":
+			a = s[i].CumCompileTime
+			b = s[j].CumCompileTime
+		case "COPTIME":
+			a = s[i].CumCopTime
+			b = s[j].CumCopTime
+		case "PROCESSTIME":
+			a = s[i].CumProcessTime
+			b = s[j].CumProcessTime
+		case "BACKOFFTIME":
+			a = s[i].CumBackoffTime
+			b = s[j].CumBackoffTime
+		case "PROCESSKEYS":
+			a = float64(s[i].CumProcessKeys)
+			b = float64(s[j].CumProcessKeys)
+		case "TOTALKEYS":
+			a = float64(s[i].CumTotalKeys)
+			b = float64(s[j].CumTotalKeys)
+		case "MEM", "MEMMAX":
+			a = float64(s[i].MaxMemMax)
+			b = float64(s[j].MaxMemMax)
 		// case "TIME":
 		default:
 			a = s[i].CumQueryTime
@@ -729,8 +1236,7 @@ func displayReport(querylist map[[32]byte]*outputs.QueryStats, sinfo *outputs.Se
 		}
 		log.Infof("caching results in %s", cachefile)
 		defer w.Close()
-		//outputs.Outputs["null"](servermeta, s, w)
-		outputs.Outputs["json"](servermeta, s, w)
+		writeCache(w, meta, s)
 	}
 
 	// Keep top queries
@@ -738,7 +1244,44 @@ func displayReport(querylist map[[32]byte]*outputs.QueryStats, sinfo *outputs.Se
 		s = s[:Config.Top]
 	}
 
-	outputs.Outputs[Config.Output](servermeta, s, os.Stdout)
+	outputs.Outputs[Config.Output](meta, s, os.Stdout)
+}
+
+// cacheOptions returns options results depend on; they are saved in cache,
+// which is not used when they differ
+// Display options (--top, --sort, --output, ...) are not part of them; input
+// readers options are
+func cacheOptions() map[string]string {
+	options := inputs.Options()
+
+	options["group-by"] = Config.GroupBy
+	options["input-format"] = Config.InputFormat
+	options["timezone"] = Config.Timezone
+	options["admin-commands"] = strconv.FormatBool(Config.AdminCommands)
+	options["multi-statements"] = Config.MultiStatements
+	options["container-log"] = Config.ContainerLog
+	options["per-source"] = strconv.FormatBool(Config.PerSource)
+
+	return options
+}
+
+// writeCache writes results to w, along with options they depend on
+func writeCache(w io.Writer, meta outputs.ServerInfo, s outputs.QueryStatsSlice) {
+	c := outputs.CacheInfo{
+		Server:  meta,
+		Queries: s,
+		Options: cacheOptions(),
+	}
+
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		log.Errorf("unable to marshal cache: %v", err)
+		return
+	}
+
+	if _, err := w.Write(data); err != nil {
+		log.Errorf("unable to write cache: %v", err)
+	}
 }
 
 func runFromCache(file string) bool {
@@ -785,6 +1328,13 @@ func runFromCache(file string) bool {
 		return false
 	}
 
+	for k, v := range cacheOptions() {
+		if entries.Options[k] != v {
+			log.Infof("skipping cache computed with different %s option", k)
+			return false
+		}
+	}
+
 	if len(entries.Queries) > Config.Top {
 		entries.Queries = entries.Queries[:Config.Top]
 	}
@@ -2,13 +2,22 @@ package main
 
 import (
 	"bufio"
-	"fmt"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
 	"gitlab.com/devopsworks/tools/dw-query-digest/outputs"
 )
 
@@ -32,6 +41,7 @@ func TestFingerprint(t *testing.T) {
 		// 2·   Shorten multi-value INSERT statements to a single VALUES() list.
 		{`INSERT INTO foo ('a','b','c') VALUES ('hey','dude')`, `insert into foo ('a','b','c') values (?)`},
 		{`INSERT INTO foo ('a','b','c') VALUES('hey','dude')`, `insert into foo ('a','b','c') values (?)`},
+		{`INSERT INTO t VALUES (1),(2); UPDATE t SET a = 2;`, `insert into t values (?); update t set a = ?;`},
 		// 3·   Strip comments.
 		{`SELECT * FROM table /* with comment */`, `select * from table`},
 		{`SELECT * FROM /* with comment */ table`, `select * from table`},
@@ -48,6 +58,12 @@ func TestFingerprint(t *testing.T) {
 		{`AAA`, `aaa`},
 		// 8·   Replace all literals inside of IN() and VALUES() lists with a single placeholder, regardless of cardinality.
 		{`SELECT  *  FROM  table  WHERE foo in (1,2,3,4)`, `select * from table where foo in (?)`},
+		// Stored procedures calls
+		{`CALL refresh_stock(42, 'a,b')`, `call refresh_stock(?)`},
+		{"call `shop`.`refresh_stock` (1);", "call `shop`.`refresh_stock`(?);"},
+		{`CALL nightly`, `call nightly(?)`},
+		{`CALL p(1); CALL q(2);`, `call p(?); call q(?);`},
+		{`CALL p(now(), ')'); SELECT * FROM t WHERE a = 1;`, `call p(?); select * from t where a = ?;`},
 	}
 
 	for _, tt := range queries {
@@ -60,141 +76,425 @@ func TestFingerprint(t *testing.T) {
 		}
 	}
 }
-func TestLineCounter(t *testing.T) {
 
-	var lentests = []int{0, 1, 1000, 1000000}
+func TestWorkerAdminCommands(t *testing.T) {
+	defer func(enabled bool) { Config.AdminCommands = enabled }(Config.AdminCommands)
 
-	for _, tt := range lentests {
-		t.Run(fmt.Sprintf("%d", tt), func(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		Config.AdminCommands = enabled
 
-			f, err := ioutil.TempFile("", "linecountbench")
-			if err != nil {
-				t.Fatalf("unable to create linecount file for testing")
-			}
+		var wg sync.WaitGroup
+		events := make(chan query, 2)
+		queries := make(chan query, 2)
 
-			defer os.Remove(f.Name())
+		events <- query{FullQuery: "administrator command: Quit", FingerPrint: "administrator command: quit", Admin: true}
+		events <- query{FullQuery: "SELECT 1"}
+		close(events)
 
-			for i := 0; i < tt; i++ {
-				_, err = f.WriteString("\n")
-				if err != nil {
-					t.Fatalf("unable to write string: %v", err)
-				}
-			}
+		wg.Add(1)
+		worker(&wg, events, queries)
+		close(queries)
 
-			_, err = f.Seek(0, 0)
+		var got []string
+		for qry := range queries {
+			got = append(got, qry.FingerPrint)
+		}
 
-			if err != nil {
-				t.Fatalf("unable to seek to start")
-			}
+		if enabled {
+			assert.Equal(t, []string{"administrator command: quit", "select 1"}, got, "should be equal")
+		} else {
+			assert.Equal(t, []string{"select 1"}, got, "should be equal")
+		}
+	}
+}
 
-			l, err := lineCounter(f)
-			assert.Nil(t, err)
-			assert.Equal(t, tt, l, "should be equal")
+func TestProcedureRanking(t *testing.T) {
+	defer func(p map[string]*outputs.ProcedureStats) { procstats = p }(procstats)
+	procstats = map[string]*outputs.ProcedureStats{}
+
+	for _, qry := range []query{
+		{FingerPrint: "call refresh_stock(?);", Schema: "shop", QueryTime: 1.5},
+		{FingerPrint: "update stock set qty = qty - ? where id = ?;", Routine: "shop.refresh_stock", Schema: "shop", QueryTime: 1.2},
+		{FingerPrint: "select * from stock;", Routine: "shop.refresh_stock", Schema: "shop", QueryTime: 0.2},
+		{FingerPrint: "call `shop`.`refresh_stock`(?);", Schema: "other", QueryTime: 0.5},
+		// Calls not logged (log_slow_sp_statements=OFF_NO_CALLS)
+		{FingerPrint: "delete from carts;", Routine: "`shop`.`purge`", QueryTime: 3},
+		{FingerPrint: "select ?;", Schema: "shop", QueryTime: 10},
+	} {
+		qry := qry
+		addProcedureStats(&qry)
+	}
 
-			f.Close()
-		})
+	procs := procedureRanking()
+	if !assert.Len(t, procs, 2) {
+		return
 	}
+
+	assert.Equal(t, "shop.purge", procs[0].Name, "should be equal")
+	assert.Equal(t, 0, procs[0].Calls, "should be equal")
+	assert.Equal(t, 1, procs[0].Statements, "should be equal")
+	assert.Equal(t, 3.0, procs[0].CumTime, "should be equal")
+
+	assert.Equal(t, "shop.refresh_stock", procs[1].Name, "should be equal")
+	assert.Equal(t, 2, procs[1].Calls, "should be equal")
+	assert.Equal(t, 2, procs[1].Statements, "should be equal")
+	assert.InDelta(t, 2.0, procs[1].CumTime, 1e-9)
+	assert.InDelta(t, 1.4, procs[1].CumStatementTime, 1e-9)
 }
 
-func TestParseHeader(t *testing.T) {
-	// 	s := `/usr/sbin/mysqld, Version: 5.7.19-17-57-log (Percona XtraDB Cluster (GPL), Release rel17, Revision 35cdc81, WSREP version 29.22, wsrep_29.22). started with:
-	// Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
-	// Time                 Id Command    Argument`
+func TestDecompress(t *testing.T) {
+	content := "SELECT 1;\n"
+
+	gz := bytes.Buffer{}
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(content))
+	gw.Close()
+
+	xzb := bytes.Buffer{}
+	xw, _ := xz.NewWriter(&xzb)
+	xw.Write([]byte(content))
+	xw.Close()
+
+	zs := bytes.Buffer{}
+	zw, _ := zstd.NewWriter(&zs)
+	zw.Write([]byte(content))
+	zw.Close()
 
-	var headertests = []struct {
-		label  string
-		hasErr bool
-		header string
-		out    outputs.ServerInfo
+	// stdlib has no bzip2 writer
+	bz, _ := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWb43xuUAAAReAAAQQAAgCAoEDAAgACIGhtQgyYhCzmWzxdyRThQkL43xuUA=")
+
+	var tests = []struct {
+		label string
+		input []byte
 	}{
-		{
-			label:  "good header",
-			hasErr: false,
-			header: `/usr/sbin/mysqld, Version: 5.7.19-17-57-log (Percona XtraDB Cluster (GPL), Release rel17, Revision 35cdc81, WSREP version 29.22, wsrep_29.22). started with:
-Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
-Time                 Id Command    Argument`,
-			out: outputs.ServerInfo{
-				Binary:             "/usr/sbin/mysqld",
-				VersionShort:       "5.7.19",
-				Version:            "5.7.19-17-57-log",
-				VersionDescription: "Percona XtraDB Cluster (GPL), Release rel17, Revision 35cdc81, WSREP version 29.22, wsrep_29.22",
-				TCPPort:            3306,
-				UnixSocket:         "/var/run/mysqld/mysqld.sock",
-			},
-		},
-		{
-			label:  "bad header",
-			hasErr: true,
-
-			header: `crap`,
-			out: outputs.ServerInfo{
-				Binary:             "unable to parse line",
-				VersionShort:       "unable to parse line",
-				Version:            "unable to parse line",
-				VersionDescription: "unable to parse line",
-				TCPPort:            0,
-				UnixSocket:         "unable to parse line",
-			},
-		},
-	}
-
-	for _, tt := range headertests {
+		{"", []byte(content)},
+		{"gzip", gz.Bytes()},
+		{"bzip2", bz},
+		{"xz", xzb.Bytes()},
+		{"zstd", zs.Bytes()},
+	}
+
+	for _, tt := range tests {
 		t.Run(tt.label, func(t *testing.T) {
-			r := strings.NewReader(tt.header)
-			scanner := bufio.NewScanner(r)
-			buf := make([]byte, 0, 64*1024)
-			scanner.Buffer(buf, 1024*1024)
+			r, compression, err := decompress(bytes.NewReader(tt.input))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.label, compression, "should be equal")
 
-			servermeta := outputs.ServerInfo{}
+			out, err := ioutil.ReadAll(r)
+			assert.Nil(t, err)
+			assert.Equal(t, content, string(out), "should be equal")
+			assert.Nil(t, r.Close())
+		})
+	}
+}
 
-			err := parseHeader(scanner, &servermeta)
+func TestSortRotated(t *testing.T) {
+	files := []string{"slow.log", "b/slow.log.1", "slow.log.1", "slow.log.10.gz", "slow.log.2.zst", "b/slow.log"}
+	sortRotated(files)
 
-			if tt.hasErr {
-				assert.NotNil(t, err)
-			} else {
+	assert.Equal(t, []string{"b/slow.log.1", "b/slow.log", "slow.log.10.gz", "slow.log.2.zst", "slow.log.1", "slow.log"}, files, "should be equal")
 
-				assert.Nil(t, err)
-			}
+	// logrotate dateext
+	files = []string{"slow.log", "slow.log-20240102", "slow.log-20231231.gz", "slow.log-20240101.gz", "error.log-20240101"}
+	sortRotated(files)
 
-			assert.Equal(t, tt.out.Binary, servermeta.Binary, "should be equal")
-			assert.Equal(t, tt.out.VersionShort, servermeta.VersionShort, "should be equal")
-			assert.Equal(t, tt.out.Version, servermeta.Version, "should be equal")
-			assert.Equal(t, tt.out.VersionDescription, servermeta.VersionDescription, "should be equal")
-			assert.Equal(t, tt.out.TCPPort, servermeta.TCPPort, "should be equal")
-			assert.Equal(t, tt.out.UnixSocket, servermeta.UnixSocket, "should be equal")
-		})
+	assert.Equal(t, []string{"error.log-20240101", "slow.log-20231231.gz", "slow.log-20240101.gz", "slow.log-20240102", "slow.log"}, files, "should be equal")
+}
+
+func TestExpandSources(t *testing.T) {
+	defer func(c options) { Config = c }(Config)
+	Config.InputFormat = "auto"
+	Config.ContainerLog = "auto"
 
+	dir, err := ioutil.TempDir("", "expandsources")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, f := range []string{"slow.log", "slow.log.1", "slow.log.2.gz", "slow.log.cache"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte{}, 0644); err != nil {
+			t.Fatalf("unable to create %s: %v", f, err)
+		}
+	}
+
+	// Files in no known format are skipped in directories
+	contents := map[string]string{
+		"slow.log.3": "# Time: 2019-02-25T10:09:51.178210Z\n# User@Host: root[root] @ localhost []  Id:     8\n# Query_time: 2.000000  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 20\nSELECT 1;\n",
+		"error.log":  "2024-01-01T00:00:00.000000Z 0 [System] [MY-010931] [Server] /usr/sbin/mysqld: ready for connections.\n",
+	}
+	for f, content := range contents {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(content), 0644); err != nil {
+			t.Fatalf("unable to create %s: %v", f, err)
+		}
+	}
+
+	want := []string{filepath.Join(dir, "slow.log.3"), filepath.Join(dir, "slow.log.2.gz"), filepath.Join(dir, "slow.log.1"), filepath.Join(dir, "slow.log")}
+
+	files, err := expandSources([]string{dir})
+	assert.Nil(t, err)
+	assert.Equal(t, want, files, "should be equal")
+
+	files, err = expandSources([]string{filepath.Join(dir, "slow.log*"), filepath.Join(dir, "slow.log")})
+	assert.Nil(t, err)
+	assert.Equal(t, append(want, filepath.Join(dir, "slow.log.cache")), files, "should be equal")
+
+	_, err = expandSources([]string{"-", filepath.Join(dir, "slow.log")})
+	assert.NotNil(t, err)
+
+	_, err = expandSources([]string{filepath.Join(dir, "nothing*")})
+	assert.NotNil(t, err)
+
+	empty, err := ioutil.TempDir(dir, "empty")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
 	}
+	_, err = expandSources([]string{empty})
+	assert.NotNil(t, err)
 }
 
-func BenchmarkLineCounter(b *testing.B) {
-	f, err := ioutil.TempFile("", "linecountbench")
+func TestRunFromCache(t *testing.T) {
+	defer func(c options) { Config = c }(Config)
+	Config.Output = "null"
+	Config.Top = 20
+	Config.GroupBy = "fingerprint"
+	Config.InputFormat = "auto"
+	Config.Timezone = "UTC"
+
+	dir, err := ioutil.TempDir("", "runfromcache")
 	if err != nil {
-		b.Fatalf("unable to create linecount file for benchmark")
+		t.Fatalf("unable to create temp dir: %v", err)
 	}
+	defer os.RemoveAll(dir)
 
-	defer os.Remove(f.Name())
+	file := filepath.Join(dir, "slow.log")
+	if err := ioutil.WriteFile(file, []byte{}, 0644); err != nil {
+		t.Fatalf("unable to create %s: %v", file, err)
+	}
 
-	for i := 0; i < 100000; i++ {
-		_, err = f.WriteString("aaaa\n")
-		if err != nil {
-			b.Fatalf("unable to write string aaaa: %v", err)
-		}
+	w, err := os.Create(file + ".cache")
+	if err != nil {
+		t.Fatalf("unable to create cache: %v", err)
 	}
+	writeCache(w, outputs.ServerInfo{}, outputs.QueryStatsSlice{})
+	w.Close()
 
-	for n := 0; n < b.N; n++ {
-		_, err = f.Seek(0, 0)
+	tests := []struct {
+		name   string
+		change func()
+		want   bool
+	}{
+		{"same options", func() {}, true},
+		{"display options", func() { Config.Top = 1; Config.SortKey = "count" }, true},
+		{"group-by", func() { Config.GroupBy = "digest" }, false},
+		{"input-format", func() { Config.InputFormat = "slowlog" }, false},
+		{"timezone", func() { Config.Timezone = "Europe/Paris" }, false},
+		{"admin-commands", func() { Config.AdminCommands = !Config.AdminCommands }, false},
+		{"multi-statements", func() { Config.MultiStatements = "split" }, false},
+		{"mysql-port", func() { flag.Set("mysql-port", "3307") }, false},
+		{"pg-log-line-prefix", func() { flag.Set("pg-log-line-prefix", "%t ") }, false},
+		{"container-log", func() { Config.ContainerLog = "docker" }, false},
+		{"per-source", func() { Config.PerSource = !Config.PerSource }, false},
+	}
 
+	for _, tt := range tests {
+		saved := Config
+		port, prefix := flag.Lookup("mysql-port").Value.String(), flag.Lookup("pg-log-line-prefix").Value.String()
+		tt.change()
+		assert.Equal(t, tt.want, runFromCache(file), tt.name)
+		Config = saved
+		flag.Set("mysql-port", port)
+		flag.Set("pg-log-line-prefix", prefix)
+	}
+}
+
+// readQueries reads r using format reader & returns queries as sent by
+// workers
+func readQueries(format string, r io.Reader, source string) []query {
+	events := make(chan query, 1000)
+	readInput(format, r, source, events)
+	close(events)
+
+	var wg sync.WaitGroup
+	queries := make(chan query, 1000)
+	wg.Add(1)
+	worker(&wg, events, queries)
+	close(queries)
+
+	var got []query
+	for q := range queries {
+		got = append(got, q)
+	}
+	return got
+}
+
+func TestDigestSummaries(t *testing.T) {
+	pfs := "SCHEMA_NAME\tDIGEST\tDIGEST_TEXT\tCOUNT_STAR\tSUM_TIMER_WAIT\tMIN_TIMER_WAIT\tMAX_TIMER_WAIT\tSUM_ERRORS\tSUM_ROWS_SENT\tQUANTILE_95\tFIRST_SEEN\tLAST_SEEN\n" +
+		"shop\tabc\tSELECT * FROM `users` WHERE `id` = ?\t100\t2000000000000\t1000000000\t90000000000\t2\t100\t50000000000\t2021-03-01 10:00:00.000000\t2021-03-01 11:00:00.000000\n" +
+		"crm\tabc\tSELECT * FROM `users` WHERE `id` = ?\t10\t1000000000000\t500000000\t100000000000\t0\t10\t60000000000\t2021-03-01 09:00:00.000000\t2021-03-01 10:30:00.000000\n"
+
+	defer func(meta outputs.ServerInfo) { servermeta = meta }(servermeta)
+	servermeta = outputs.ServerInfo{}
+	got := readQueries("auto", strings.NewReader(pfs), "pfs")
+
+	if !assert.Len(t, got, 2) {
+		return
+	}
+
+	// Rows of both schemas are merged
+	assert.Equal(t, "select * from users where id = ?;", got[0].FingerPrint, "should be equal")
+	assert.Equal(t, got[0].Hash, got[1].Hash, "should be equal")
+
+	// Rows are merged with the same queries read from logs
+	slow := readQueries("slowlog", strings.NewReader("# Time: 2021-03-01T10:00:00.000000Z\n"+
+		"# User@Host: root[root] @ localhost []  Id:     8\n"+
+		"# Query_time: 0.5  Lock_time: 0.0 Rows_sent: 1  Rows_examined: 1\n"+
+		"SELECT * FROM users WHERE id = 42;\n"), "slow")
+	if assert.Len(t, slow, 1) {
+		assert.Equal(t, got[0].Hash, slow[0].Hash, "should be equal")
+	}
+
+	qs := &outputs.QueryStats{}
+	for _, qry := range got {
+		qry := qry
+		addSummary(qs, &qry)
+	}
+
+	assert.Equal(t, 110, qs.Count, "should be equal")
+	assert.Equal(t, 2, qs.CumErrored, "should be equal")
+	assert.Equal(t, 3.0, qs.CumQueryTime, "should be equal")
+	assert.Equal(t, 110, qs.CumRowsSent, "should be equal")
+	assert.Equal(t, 0, qs.QueryPlanCount, "should be equal")
+	if assert.NotNil(t, qs.QueryTimeSummary) {
+		assert.Equal(t, 0.0005, qs.QueryTimeSummary.Min, "should be equal")
+		assert.Equal(t, 0.1, qs.QueryTimeSummary.Max, "should be equal")
+		assert.Equal(t, 0.06, qs.QueryTimeSummary.P95, "should be equal")
+		assert.InDelta(t, 3.0/110, qs.QueryTimeSummary.Mean, 1e-9)
+	}
+
+	// Log samples of the same fingerprint widen the distribution
+	qs.Count += 2
+	qs.CumQueryTime += 0.5 + 0.0001
+	qs.QueryTime = append(qs.QueryTime, 0.5, 0.0001)
+	mergeSamples(qs)
+	if assert.NotNil(t, qs.QueryTimeSummary) {
+		assert.Equal(t, 0.0001, qs.QueryTimeSummary.Min, "should be equal")
+		assert.Equal(t, 0.5, qs.QueryTimeSummary.Max, "should be equal")
+		assert.Equal(t, 0.5, qs.QueryTimeSummary.P95, "should be equal")
+		assert.InDelta(t, 3.5001/112, qs.QueryTimeSummary.Mean, 1e-9)
+	}
+}
+
+func TestUnwrapContainerLog(t *testing.T) {
+	want := "# Time: 2021-03-01T10:00:00.000000Z\n" +
+		"# Query_time: 0.5  Lock_time: 0.0 Rows_sent: 1  Rows_examined: 1\n" +
+		"SELECT * FROM orders WHERE id = 42;\n"
+
+	tests := []struct {
+		format string
+		input  string
+	}{
+		{"auto", `{"log":"# Time: 2021-03-01T10:00:00.000000Z\n","stream":"stdout","time":"2021-03-01T10:00:00.1Z"}` + "\n" +
+			`{"log":"# Query_time: 0.5  Lock_time: 0.0 Rows_sent: 1  Rows_examined: 1\n","stream":"stdout","time":"2021-03-01T10:00:00.1Z"}` + "\n" +
+			`{"log":"SELECT * FROM orders ","stream":"stdout","time":"2021-03-01T10:00:00.1Z"}` + "\n" +
+			`{"log":"WHERE id = 42;\n","stream":"stdout","time":"2021-03-01T10:00:00.1Z"}` + "\n"},
+		{"auto", "2021-03-01T10:00:00.1Z stdout F # Time: 2021-03-01T10:00:00.000000Z\n" +
+			"2021-03-01T10:00:00.1Z stdout F # Query_time: 0.5  Lock_time: 0.0 Rows_sent: 1  Rows_examined: 1\n" +
+			"2021-03-01T10:00:00.1Z stdout P SELECT * FROM orders \n" +
+			"2021-03-01T10:00:00.1Z stdout F WHERE id = 42;\n"},
+		{"journald", `{"__CURSOR":"s=1","MESSAGE":"# Time: 2021-03-01T10:00:00.000000Z"}` + "\n" +
+			`{"__CURSOR":"s=2","MESSAGE":"# Query_time: 0.5  Lock_time: 0.0 Rows_sent: 1  Rows_examined: 1"}` + "\n" +
+			`{"__CURSOR":"s=3","MESSAGE":[83,69,76,69,67,84,32,42,32,70,82,79,77,32,111,114,100,101,114,115,32],"_LINE_BREAK":"line-max"}` + "\n" +
+			`{"__CURSOR":"s=4","MESSAGE":"WHERE id = 42;"}` + "\n"},
+		{"auto", want},
+		{"none", want},
+	}
+
+	for _, tt := range tests {
+		got, err := ioutil.ReadAll(unwrapContainerLog(strings.NewReader(tt.input), tt.format, "test"))
+		assert.Nil(t, err)
+		assert.Equal(t, want, string(got), "should be equal")
+	}
+
+	// Lines longer than bufio.Scanner default limits
+	long := "INSERT INTO t VALUES " + strings.Repeat("(1),", 512*1024) + "(1);"
+	input := `{"log":"# Time: 2021-03-01T10:00:00.000000Z\n","stream":"stdout"}` + "\n" +
+		`{"log":"` + long + `\n","stream":"stdout"}` + "\n" +
+		`{"log":"SELECT 1;\n","stream":"stdout"}` + "\n"
+
+	got, err := ioutil.ReadAll(unwrapContainerLog(strings.NewReader(input), "auto", "test"))
+	assert.Nil(t, err)
+	assert.Equal(t, "# Time: 2021-03-01T10:00:00.000000Z\n"+long+"\nSELECT 1;\n", string(got), "should be equal")
+}
+
+func TestGroupHash(t *testing.T) {
+	fp := "select c from sbtest1 where id = ?"
+	got := []query{
+		{FingerPrint: fp, Hash: sha256.Sum256([]byte(fp)), Digest: "0x3765930C7143F468", Hostgroup: "10", Backend: "db-1:3306"},
+		{FingerPrint: fp, Hash: sha256.Sum256([]byte(fp)), Digest: "0x3765930C7143F468", Hostgroup: "20", Backend: "db-2:3306"},
+	}
+
+	assert.Equal(t, got[0].Hash, groupHash(&got[0], []string{"fingerprint"}), "should be equal")
+	assert.Equal(t, groupHash(&got[0], []string{"digest"}), groupHash(&got[1], []string{"digest"}), "should be equal")
+	assert.NotEqual(t, groupHash(&got[0], []string{"digest", "hostgroup"}), groupHash(&got[1], []string{"digest", "hostgroup"}))
+
+	assert.Nil(t, checkGroupBy([]string{"digest", "backend"}))
+	assert.NotNil(t, checkGroupBy([]string{"server"}))
+}
+
+func TestSniffInputFormat(t *testing.T) {
+	tests := []struct {
+		want  string
+		input string
+	}{
+		{"slowlog", "/usr/sbin/mysqld, Version: 5.7.28-log (MySQL Community Server (GPL)). started with:\n" +
+			"Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock\n" +
+			"Time                 Id Command    Argument\n" +
+			"# Time: 2020-02-26T14:46:34.123456Z\n" +
+			"# User@Host: root[root] @ localhost []  Id:     3\n" +
+			"# Query_time: 0.000171  Lock_time: 0.000054 Rows_sent: 1  Rows_examined: 0\n" +
+			"SET timestamp=1582728394;\n" +
+			"select 1;\n"},
+		{"slowlog", "# Time: 200226 14:46:34\n"},
+		{"slowlog", "something unknown\n"},
+		{"tidb", "# Time: 2019-08-14T09:26:59.487776265+08:00\n# Txn_start_ts: 410450924122144769\n"},
+		{"genlog", "/usr/sbin/mysqld, Version: 5.7.28-log (MySQL Community Server (GPL)). started with:\n" +
+			"Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock\n" +
+			"Time                 Id Command    Argument\n" +
+			"2020-02-26T14:46:34.123456Z\t    3 Query\tselect 1\n"},
+		{"audit", `{"audit_record":{"name":"Query","timestamp":"2020-02-26T14:46:34Z","connection_id":"10","status":0,"sqltext":"select 1"}}` + "\n"},
+		{"audit", "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<AUDIT>\n <AUDIT_RECORD>\n"},
+		{"audit", "20200226 14:46:34,db1,root,127.0.0.1,10,102,QUERY,test,'select 1',0\n"},
+		{"audit", `[` + "\n" + `{ "timestamp": "2020-02-26 14:46:34", "id": 1, "class": "general", "event": "status", "connection_id": 10 }` + "\n"},
+		{"binlog", "/*!50530 SET @@SESSION.PSEUDO_SLAVE_MODE=1*/;\nDELIMITER /*!*/;\n"},
+		{"proxysql", `{"client":"10.0.0.5:39954","digest":"0x3765930C7143F468","duration_us":1520,"event":"COM_QUERY","hostgroup_id":10,"query":"select 1"}` + "\n"},
+		{"postgres", "2020-02-26 14:46:34.123 UTC [1234] LOG:  duration: 0.123 ms  statement: select 1\n"},
+		{"digest", "query,db,full_scan,exec_count,err_count,total_latency,max_latency\n\"SELECT 1\",shop,,4,0,2.00 s,1.50 s\n"},
+		{"postgres-csv", `2020-02-26 14:46:34.123 UTC,"postgres","test",1234,"[local]",5e56853a.4d2,1,"SELECT",2020-02-26 14:46:30 UTC,3/4,0,LOG,00000,"duration: 0.123 ms  statement: select 1",,,,,,,,,"psql"` + "\n"},
+		{"slowlog", `{"log":"# Time: 2021-03-01T10:00:00.000000Z\n","stream":"stdout","time":"2021-03-01T10:00:00.1Z"}` + "\n" +
+			`{"log":"# Query_time: 0.5  Lock_time: 0.0 Rows_sent: 1  Rows_examined: 1\n","stream":"stdout","time":"2021-03-01T10:00:00.1Z"}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		r := bufio.NewReader(unwrapContainerLog(strings.NewReader(tt.input), "auto", "test"))
+		assert.Equal(t, tt.want, sniffInputFormat(r), "should be equal")
+	}
+
+	files := map[string]string{
+		"inputs/cloudwatch/testdata/cloudwatch-export.json":            "cloudwatch",
+		"inputs/cloudwatch/testdata/cloudwatch-filter-log-events.json": "cloudwatch",
+		"inputs/pcap/testdata/mysql.pcap":                              "pcap",
+		"inputs/pcap/testdata/mysql.pcapng":                            "pcap",
+	}
+
+	for file, want := range files {
+		f, err := os.Open(file)
 		if err != nil {
-			b.Fatalf("unable to seek to start")
-		}
-		_, err = lineCounter(f)
-		if err != nil {
-			b.Fatalf("call to lineCounter failed: %v", err)
+			t.Fatalf("unable to open %s: %v", file, err)
 		}
+		assert.Equal(t, want, sniffInputFormat(bufio.NewReader(f)), "should be equal")
+		f.Close()
 	}
-
-	f.Close()
 }
 
 func benchmarkFingerprint(statement string, b *testing.B) {
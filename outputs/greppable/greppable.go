@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 	"time"
 
 	"gonum.org/v1/gonum/stat"
@@ -22,29 +23,105 @@ func Display(servermeta outputs.ServerInfo, s outputs.QueryStatsSlice, w io.Writ
 	fmt.Fprintf(w, "Total queries:%.3fM (%d);", float64(servermeta.QueryCount)/1000000.0, servermeta.QueryCount)
 	fmt.Fprintf(w, "Total bytes:%.3fM (%d);", float64(servermeta.CumBytes)/1000000.0, servermeta.CumBytes)
 	fmt.Fprintf(w, "Total fingerprints:%d;", servermeta.UniqueQueries)
-	fmt.Fprintf(w, "Capture start:%s;", servermeta.Start)
-	fmt.Fprintf(w, "Capture end:%s;", servermeta.End)
-	fmt.Fprintf(w, "Duration:%s (%d s);", servermeta.End.Sub(servermeta.Start), servermeta.End.Sub(servermeta.Start)/time.Second)
-	fmt.Fprintf(w, "QPS:%.0f\n", float64(time.Second)*(float64(servermeta.QueryCount)/float64(servermeta.End.Sub(servermeta.Start))))
+	if servermeta.HasTimeRange() {
+		fmt.Fprintf(w, "Capture start:%s;", servermeta.Start)
+		fmt.Fprintf(w, "Capture end:%s;", servermeta.End)
+		fmt.Fprintf(w, "Duration:%s (%d s);", servermeta.End.Sub(servermeta.Start), servermeta.End.Sub(servermeta.Start)/time.Second)
+		fmt.Fprintf(w, "QPS:%.0f\n", float64(time.Second)*(float64(servermeta.QueryCount)/float64(servermeta.End.Sub(servermeta.Start))))
+	} else {
+		// Time range is unknown (e.g. digest summaries without FIRST_SEEN)
+		fmt.Fprintf(w, "Capture start:;Capture end:;Duration:;QPS:\n")
+	}
 
 	fmt.Fprintf(w, "# 1_Pos;2_QueryID;3_Fingerprint;4_Schema;5_Calls;")
 	fmt.Fprintf(w, "6_CumErrored;7_CumKilled;8_CumQueryTime(s);9_CumLockTime(s);10_CumRowsSent;")
 	fmt.Fprintf(w, "11_CumRowsExamined;12_CumRowsAffected;13_CumBytesSent;14_Concurency(%%);15_Min(s);16_Max(s);")
-	fmt.Fprintf(w, "17_Mean(s);18_P50(s);19_P95(s);20_StdDev(s)\n")
+	fmt.Fprintf(w, "17_Mean(s);18_P50(s);19_P95(s);20_StdDev(s);")
+	fmt.Fprintf(w, "21_QCHit(%%);22_FullScan(%%);23_FullJoin(%%);24_TmpTable(%%);25_TmpTableOnDisk(%%);")
+	fmt.Fprintf(w, "26_Filesort(%%);27_FilesortOnDisk(%%);28_CumTmpTables;29_CumTmpDiskTables;30_CumTmpTableSizes;")
+	fmt.Fprintf(w, "31_CumMergePasses;32_CumInnoDBIOReadOps;33_CumInnoDBIOReadBytes;34_CumInnoDBIOReadWait(s);")
+	fmt.Fprintf(w, "35_CumInnoDBRecLockWait(s);36_CumInnoDBQueueWait(s);37_CumInnoDBPagesDistinct;")
+	fmt.Fprintf(w, "38_CumBytesReceived;39_CumReadFirst;40_CumReadLast;41_CumReadKey;42_CumReadNext;43_CumReadPrev;")
+	fmt.Fprintf(w, "44_CumReadRnd;45_CumReadRndNext;46_CumSortRangeCount;47_CumSortRows;48_CumSortScanCount;")
+	fmt.Fprintf(w, "49_PerSource(name=calls/s,...);")
+	fmt.Fprintf(w, "50_CumParseTime(s);51_CumCompileTime(s);52_CumCopTime(s);53_CumProcessTime(s);54_CumWaitTime(s);")
+	fmt.Fprintf(w, "55_CumBackoffTime(s);56_CumProcessKeys;57_CumTotalKeys;58_CumCopTasks;59_CumWriteKeys;60_MaxMemMax;61_MaxDiskMax;")
+	fmt.Fprintf(w, "62_Digest;63_Hostgroup;64_Backend\n")
+
+	ffactor := 0.0
+	if servermeta.HasTimeRange() {
+		ffactor = 100.0 * float64(time.Second) / float64(servermeta.End.Sub(servermeta.Start))
+	}
 
-	ffactor := 100.0 * float64(time.Second) / float64(servermeta.End.Sub(servermeta.Start))
+	// concurrency is empty when time range is unknown
+	concurrency := func(val *outputs.QueryStats) string {
+		if !servermeta.HasTimeRange() {
+			return ""
+		}
+		return fmt.Sprintf("%2.2f%%", val.Concurrency)
+	}
 	for idx, val := range s {
 		val.Concurrency = val.CumQueryTime * ffactor
 		sort.Float64s(val.QueryTime)
 
-		// We need %s%s since val.FingerPrint comes with a ';' at the end
-		fmt.Fprintf(w, "%d;%x;%s%s;%d;", idx+1, val.Hash[0:5], val.FingerPrint, val.Schema, val.Count)
-		fmt.Fprintf(w, "%d;%d;%f;%f;%d;", val.CumErrored, val.CumKilled, val.CumQueryTime, val.CumLockTime, val.CumRowsSent)
-		fmt.Fprintf(w, "%d;%d;%d;%2.2f%%;%f;%f;", val.CumRowsExamined, val.CumRowsAffected, val.CumBytesSent, val.Concurrency, val.QueryTime[0], val.QueryTime[len(val.QueryTime)-1])
-		fmt.Fprintf(w, "%f;%f;", stat.Mean(val.QueryTime, nil), stat.Quantile(0.5, 1, val.QueryTime, nil))
-		fmt.Fprintf(w, "%f;%f\n", stat.Quantile(0.95, 1, val.QueryTime, nil), stat.StdDev(val.QueryTime, nil))
+		// We need %s%s%s since val.FingerPrint usually comes with a ';' at the end
+		// (but not always, e.g. in general logs)
+		sep := ""
+		if !strings.HasSuffix(val.FingerPrint, ";") {
+			sep = ";"
+		}
+		fmt.Fprintf(w, "%d;%x;%s%s%s;%d;", idx+1, val.Hash[0:5], val.FingerPrint, sep, val.Schema, val.Count)
+		fmt.Fprintf(w, "%d;%d;", val.CumErrored, val.CumKilled)
+		if val.NoTiming {
+			// Time based metrics are not available (e.g. general log); rows
+			// affected are (e.g. row based binary log events), rows & bytes
+			// may be
+			fmt.Fprintf(w, ";;%s;%s;%d;%s;", counter(val.CumRowsSent), counter(val.CumRowsExamined), val.CumRowsAffected, counter(val.CumBytesSent))
+			fmt.Fprintf(w, "%s", strings.Repeat(";", 7))
+		} else if sum := val.QueryTimeSummary; sum != nil {
+			// Pre-aggregated statistics (performance_schema digests) have no
+			// median nor standard deviation
+			fmt.Fprintf(w, "%f;%f;%d;", val.CumQueryTime, val.CumLockTime, val.CumRowsSent)
+			fmt.Fprintf(w, "%d;%d;%d;%s;%f;%f;", val.CumRowsExamined, val.CumRowsAffected, val.CumBytesSent, concurrency(val), sum.Min, sum.Max)
+			fmt.Fprintf(w, "%f;;%f;;", sum.Mean, sum.P95)
+		} else {
+			fmt.Fprintf(w, "%f;%f;%d;", val.CumQueryTime, val.CumLockTime, val.CumRowsSent)
+			fmt.Fprintf(w, "%d;%d;%d;%s;%f;%f;", val.CumRowsExamined, val.CumRowsAffected, val.CumBytesSent, concurrency(val), val.QueryTime[0], val.QueryTime[len(val.QueryTime)-1])
+			fmt.Fprintf(w, "%f;%f;", stat.Mean(val.QueryTime, nil), stat.Quantile(0.5, 1, val.QueryTime, nil))
+			fmt.Fprintf(w, "%f;%f;", stat.Quantile(0.95, 1, val.QueryTime, nil), stat.StdDev(val.QueryTime, nil))
+		}
+		fmt.Fprintf(w, "%2.2f%%;%2.2f%%;%2.2f%%;%2.2f%%;%2.2f%%;", val.QCHitPct, val.FullScanPct, val.FullJoinPct, val.TmpTablePct, val.TmpTableOnDiskPct)
+		fmt.Fprintf(w, "%2.2f%%;%2.2f%%;%d;%d;%d;", val.FilesortPct, val.FilesortOnDiskPct, val.CumTmpTables, val.CumTmpDiskTables, val.CumTmpTableSizes)
+		fmt.Fprintf(w, "%d;%d;%d;%f;", val.CumMergePasses, val.CumInnoDBIOReadOps, val.CumInnoDBIOReadBytes, val.CumInnoDBIOReadWait)
+		fmt.Fprintf(w, "%f;%f;%d;", val.CumInnoDBRecLockWait, val.CumInnoDBQueueWait, val.CumInnoDBPagesDistinct)
+		fmt.Fprintf(w, "%d;%d;%d;%d;%d;%d;", val.CumBytesReceived, val.CumReadFirst, val.CumReadLast, val.CumReadKey, val.CumReadNext, val.CumReadPrev)
+		fmt.Fprintf(w, "%d;%d;%d;%d;%d;", val.CumReadRnd, val.CumReadRndNext, val.CumSortRangeCount, val.CumSortRows, val.CumSortScanCount)
+		fmt.Fprintf(w, "%s;", perSource(servermeta.Sources, val.PerSource))
+		fmt.Fprintf(w, "%f;%f;%f;%f;%f;", val.CumParseTime, val.CumCompileTime, val.CumCopTime, val.CumProcessTime, val.CumWaitTime)
+		fmt.Fprintf(w, "%f;%d;%d;%d;%d;%d;%d;", val.CumBackoffTime, val.CumProcessKeys, val.CumTotalKeys, val.CumCopTasks, val.CumWriteKeys, val.MaxMemMax, val.MaxDiskMax)
+		fmt.Fprintf(w, "%s;%s;%s\n", val.Digest, val.Hostgroup, val.Backend)
+	}
+
+}
+
+// counter formats a counter of queries without timing, which is empty when
+// it is not known
+func counter(n int) string {
+	if n == 0 {
+		return ""
 	}
+	return fmt.Sprintf("%d", n)
+}
 
+// perSource formats per-source breakdown as "name=calls/seconds" pairs
+func perSource(sources []outputs.SourceInfo, stats map[string]*outputs.QuerySourceStats) string {
+	pairs := []string{}
+	for _, src := range sources {
+		if st, ok := stats[src.Name]; ok {
+			pairs = append(pairs, fmt.Sprintf("%s=%d/%f", src.Name, st.Count, st.CumQueryTime))
+		}
+	}
+	return strings.Join(pairs, ",")
 }
 
 // fsecsToDuration converts float seconds to time.Duration
@@ -7,28 +7,98 @@ import (
 
 // ServerInfo holds server information gathered from first 2 log lines
 type ServerInfo struct {
-	Binary                   string    `json:"binary"`
-	VersionShort             string    `json:"versionShort"`
-	Version                  string    `json:"version"`
-	VersionDescription       string    `json:"versionDescription"`
-	TCPPort                  int       `json:"tcpPort"`
-	UnixSocket               string    `json:"unixSocket"`
-	CumBytes                 int       `json:"cumBytes"`
-	CumLines                 int       `json:"cumLines"`
-	QueryCount               int       `json:"queryCount"`
-	UniqueQueries            int       `json:"uniqueQueries"`
-	Start                    time.Time `json:"Start"`
-	End                      time.Time `json:"End"`
-	AnalysisStart            time.Time `json:"analysisStart"`
-	AnalysisEnd              time.Time `json:"analysisEnd"`
-	AnalysedLinesPerSecond   float64   `json:"analysedLinesPerSecond"`
-	AnalysedQueriesPerSecond float64   `json:"analysedQueriesPerSecond"`
-	AnalysedBytesPerSecond   float64   `json:"analysedBytesPerSecond"`
-	AnalysisDuration         float64   `json:"analysisDuration"`
+	Binary                   string           `json:"binary"`
+	VersionShort             string           `json:"versionShort"`
+	Version                  string           `json:"version"`
+	VersionDescription       string           `json:"versionDescription"`
+	TCPPort                  int              `json:"tcpPort"`
+	UnixSocket               string           `json:"unixSocket"`
+	CumBytes                 int              `json:"cumBytes"`
+	CumLines                 int              `json:"cumLines"`
+	SkippedLines             int              `json:"skippedLines"`
+	TruncatedEntries         int              `json:"truncatedEntries"`
+	QueryCount               int              `json:"queryCount"`
+	UniqueQueries            int              `json:"uniqueQueries"`
+	Start                    time.Time        `json:"Start"`
+	End                      time.Time        `json:"End"`
+	AnalysisStart            time.Time        `json:"analysisStart"`
+	AnalysisEnd              time.Time        `json:"analysisEnd"`
+	AnalysedLinesPerSecond   float64          `json:"analysedLinesPerSecond"`
+	AnalysedQueriesPerSecond float64          `json:"analysedQueriesPerSecond"`
+	AnalysedBytesPerSecond   float64          `json:"analysedBytesPerSecond"`
+	AnalysisDuration         float64          `json:"analysisDuration"`
+	NoTiming                 bool             `json:"noTiming"`
+	Segments                 []ServerSegment  `json:"segments"`
+	Sources                  []SourceInfo     `json:"sources"`
+	Transactions             *TransactionInfo `json:"transactions,omitempty"`
+	Procedures               []ProcedureStats `json:"procedures,omitempty"`
 	// May be merge querystats here with:
 	// Queries []QueryStats ?
 }
 
+// HasTimeRange returns true when capture start & end are known, so rates &
+// concurrency can be computed
+// Some inputs have no timestamps (e.g. digest summaries without FIRST_SEEN &
+// LAST_SEEN columns)
+func (s ServerInfo) HasTimeRange() bool {
+	return !s.Start.IsZero() && s.End.After(s.Start)
+}
+
+// ServerSegment holds server information found in a header block
+// A new header is written each time the server starts or logs are flushed
+type ServerSegment struct {
+	Source             string `json:"source"`
+	StartLine          int    `json:"startLine"`
+	Binary             string `json:"binary"`
+	VersionShort       string `json:"versionShort"`
+	Version            string `json:"version"`
+	VersionDescription string `json:"versionDescription"`
+	TCPPort            int    `json:"tcpPort"`
+	UnixSocket         string `json:"unixSocket"`
+}
+
+// TransactionInfo holds transaction sizes statistics (binary logs)
+// Rows are only known for row based events
+type TransactionInfo struct {
+	Count    int `json:"count"`
+	CumRows  int `json:"cumRows"`
+	MaxRows  int `json:"maxRows"`
+	CumBytes int `json:"cumBytes"`
+	MaxBytes int `json:"maxBytes"`
+}
+
+// ProcedureStats holds stored procedure statistics: CALL statements & the
+// statements they ran (Percona Server log_slow_sp_statements)
+// CumTime includes statements, since CALL durations include them
+type ProcedureStats struct {
+	Name             string  `json:"name"`
+	Calls            int     `json:"calls"`
+	CumCallTime      float64 `json:"cumCallTime"`
+	Statements       int     `json:"statements"`
+	CumStatementTime float64 `json:"cumStatementTime"`
+	CumTime          float64 `json:"cumTime"`
+}
+
+// SourceInfo holds information about an analysed input (file, stdin, ...)
+// Binary & Version come from the first server header found in the source
+type SourceInfo struct {
+	Name             string    `json:"name"`
+	Lines            int       `json:"lines"`
+	SkippedLines     int       `json:"skippedLines"`
+	TruncatedEntries int       `json:"truncatedEntries"`
+	QueryCount       int       `json:"queryCount"`
+	Start            time.Time `json:"start"`
+	End              time.Time `json:"end"`
+	Binary           string    `json:"binary"`
+	Version          string    `json:"version"`
+}
+
+// QuerySourceStats holds per-source statistics for a query
+type QuerySourceStats struct {
+	Count        int     `json:"count"`
+	CumQueryTime float64 `json:"cumQueryTime"`
+}
+
 // QueryStatsSlice holds a bunch of QueryStats
 type QueryStatsSlice []*QueryStats
 
@@ -46,6 +116,7 @@ type QueryStats struct {
 	CumRowsAffected int       `json:"cumRowsAffected"`
 	CumKilled       int       `json:"cumKilled"`
 	CumErrored      int       `json:"cumErrored"`
+	NoTiming        bool      `json:"noTiming"`
 	Concurrency     float64   `json:"concurrency"`
 	QueryTime       []float64 `json:"queryTime"`
 	BytesSent       []float64 `json:"bytesSent"`
@@ -53,12 +124,121 @@ type QueryStats struct {
 	RowsSent        []float64 `json:"rowsSent"`
 	RowsExamined    []float64 `json:"rowsExamined"`
 	RowsAffected    []float64 `json:"rowsAffected"`
+
+	// Percona extended statistics (log_slow_verbosity=full)
+	// Booleans are counted over QueryPlanCount queries and reported as
+	// percentages; InnoDB metrics are aggregated over InnoDBCount queries
+	QueryPlanCount         int       `json:"queryPlanCount"`
+	CumQCHit               int       `json:"cumQCHit"`
+	CumFullScan            int       `json:"cumFullScan"`
+	CumFullJoin            int       `json:"cumFullJoin"`
+	CumTmpTable            int       `json:"cumTmpTable"`
+	CumTmpTableOnDisk      int       `json:"cumTmpTableOnDisk"`
+	CumFilesort            int       `json:"cumFilesort"`
+	CumFilesortOnDisk      int       `json:"cumFilesortOnDisk"`
+	QCHitPct               float64   `json:"qcHitPct"`
+	FullScanPct            float64   `json:"fullScanPct"`
+	FullJoinPct            float64   `json:"fullJoinPct"`
+	TmpTablePct            float64   `json:"tmpTablePct"`
+	TmpTableOnDiskPct      float64   `json:"tmpTableOnDiskPct"`
+	FilesortPct            float64   `json:"filesortPct"`
+	FilesortOnDiskPct      float64   `json:"filesortOnDiskPct"`
+	CumTmpTables           int       `json:"cumTmpTables"`
+	CumTmpDiskTables       int       `json:"cumTmpDiskTables"`
+	CumTmpTableSizes       int       `json:"cumTmpTableSizes"`
+	CumMergePasses         int       `json:"cumMergePasses"`
+	InnoDBCount            int       `json:"innoDBCount"`
+	CumInnoDBIOReadOps     int       `json:"cumInnoDBIOReadOps"`
+	CumInnoDBIOReadBytes   int       `json:"cumInnoDBIOReadBytes"`
+	CumInnoDBIOReadWait    float64   `json:"cumInnoDBIOReadWait"`
+	CumInnoDBRecLockWait   float64   `json:"cumInnoDBRecLockWait"`
+	CumInnoDBQueueWait     float64   `json:"cumInnoDBQueueWait"`
+	CumInnoDBPagesDistinct int       `json:"cumInnoDBPagesDistinct"`
+	InnoDBIOReadWait       []float64 `json:"innoDBIOReadWait"`
+	InnoDBRecLockWait      []float64 `json:"innoDBRecLockWait"`
+	InnoDBQueueWait        []float64 `json:"innoDBQueueWait"`
+	InnoDBPagesDistinct    []float64 `json:"innoDBPagesDistinct"`
+
+	// MySQL 8.0 extra statistics (log_slow_extra=ON)
+	// aggregated over SlowExtraCount queries
+	SlowExtraCount    int `json:"slowExtraCount"`
+	CumBytesReceived  int `json:"cumBytesReceived"`
+	CumReadFirst      int `json:"cumReadFirst"`
+	CumReadLast       int `json:"cumReadLast"`
+	CumReadKey        int `json:"cumReadKey"`
+	CumReadNext       int `json:"cumReadNext"`
+	CumReadPrev       int `json:"cumReadPrev"`
+	CumReadRnd        int `json:"cumReadRnd"`
+	CumReadRndNext    int `json:"cumReadRndNext"`
+	CumSortRangeCount int `json:"cumSortRangeCount"`
+	CumSortRows       int `json:"cumSortRows"`
+	CumSortScanCount  int `json:"cumSortScanCount"`
+
+	// Server computed digest (ProxySQL, TiDB) of the first sample
+	Digest string `json:"digest,omitempty"`
+
+	// Stored procedure running the query (Percona Server
+	// log_slow_sp_statements) of the first sample
+	Routine string `json:"routine,omitempty"`
+
+	// Proxy attributes, only set when queries are grouped by them
+	Hostgroup string `json:"hostgroup,omitempty"`
+	Backend   string `json:"backend,omitempty"`
+
+	// TiDB statistics, aggregated over TiDBCount queries
+	// MaxMemMax & MaxDiskMax are the highest peaks seen
+	TiDBCount      int     `json:"tidbCount"`
+	CumParseTime   float64 `json:"cumParseTime"`
+	CumCompileTime float64 `json:"cumCompileTime"`
+	CumCopTime     float64 `json:"cumCopTime"`
+	CumProcessTime float64 `json:"cumProcessTime"`
+	CumWaitTime    float64 `json:"cumWaitTime"`
+	CumBackoffTime float64 `json:"cumBackoffTime"`
+	CumProcessKeys int     `json:"cumProcessKeys"`
+	CumTotalKeys   int     `json:"cumTotalKeys"`
+	CumCopTasks    int     `json:"cumCopTasks"`
+	CumWriteKeys   int     `json:"cumWriteKeys"`
+	CumMemMax      int     `json:"cumMemMax"`
+	MaxMemMax      int     `json:"maxMemMax"`
+	MaxDiskMax     int     `json:"maxDiskMax"`
+
+	// Execution plan of the slowest sample, if logged (MariaDB)
+	Explain *Explain `json:"explain,omitempty"`
+
+	// Per-source breakdown, only filled when requested
+	PerSource map[string]*QuerySourceStats `json:"perSource,omitempty"`
+
+	// Query time statistics computed by the server, set when individual
+	// samples are not available (performance_schema digests)
+	QueryTimeSummary *QueryTimeSummary `json:"queryTimeSummary,omitempty"`
+}
+
+// QueryTimeSummary holds pre-aggregated query time statistics (in seconds)
+// Quantiles are 0 when not provided
+type QueryTimeSummary struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Mean float64 `json:"mean"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+}
+
+// Explain holds an execution plan logged along with a query
+// (MariaDB log_slow_verbosity=explain)
+type Explain struct {
+	QueryTime float64    `json:"queryTime"`
+	Query     string     `json:"query"`
+	Columns   []string   `json:"columns"`
+	Rows      [][]string `json:"rows"`
 }
 
 // CacheInfo contains cache information
+// Options holds the options results were computed with
 type CacheInfo struct {
-	Server  ServerInfo      `json:"meta"`
-	Queries QueryStatsSlice `json:"stats"`
+	Server  ServerInfo        `json:"meta"`
+	Queries QueryStatsSlice   `json:"stats"`
+	Options map[string]string `json:"options,omitempty"`
 }
 
 // Outputs is a map containing name to function mapping
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"strings"
 	"time"
 
 	"gonum.org/v1/gonum/stat"
@@ -21,53 +22,214 @@ func Display(servermeta outputs.ServerInfo, s outputs.QueryStatsSlice, w io.Writ
 	fmt.Fprintf(w, "  TCPPort            : %d\n", servermeta.TCPPort)
 	fmt.Fprintf(w, "  UnixSocket         : %s\n", servermeta.UnixSocket)
 
+	// Only worth displaying if the server restarted or logs were flushed
+	if len(servermeta.Segments) > 1 {
+		fmt.Fprintf(w, "\n# Server Segments\n\n")
+		for _, seg := range servermeta.Segments {
+			location := fmt.Sprintf("line %d", seg.StartLine)
+			if len(servermeta.Sources) > 1 {
+				location = fmt.Sprintf("%s:%d", seg.Source, seg.StartLine)
+			}
+			fmt.Fprintf(w, "  %-15s : %s %s (port %d)\n", location, seg.Binary, seg.Version, seg.TCPPort)
+		}
+	}
+
+	// Only worth displaying when several inputs were analysed
+	if len(servermeta.Sources) > 1 {
+		fmt.Fprintf(w, "\n# Sources\n\n")
+		for _, src := range servermeta.Sources {
+			fmt.Fprintf(w, "  %s\n", src.Name)
+			fmt.Fprintf(w, "    Lines   : %d (%d skipped)\n", src.Lines, src.SkippedLines)
+			fmt.Fprintf(w, "    Queries : %d\n", src.QueryCount)
+			fmt.Fprintf(w, "    Range   : %s - %s\n", src.Start, src.End)
+			fmt.Fprintf(w, "    Server  : %s %s\n", src.Binary, src.Version)
+		}
+	}
+
 	fmt.Fprintf(w, "\n# Internal Analyzer Statistics\n\n")
 	// fmt.Fprintf(w, "  Start     : %s\n", servermeta.AnalysisStart)
 	fmt.Fprintf(w, "  Duration  : %14.3fs\n", servermeta.AnalysisDuration)
 	fmt.Fprintf(w, "  Log lines : %14.3fM (%d)\n", float64(servermeta.CumLines)/1000000.0, servermeta.CumLines)
+	fmt.Fprintf(w, "  Skipped   : %14d\n", servermeta.SkippedLines)
+	fmt.Fprintf(w, "  Truncated : %14d\n", servermeta.TruncatedEntries)
 	fmt.Fprintf(w, "  Lines/s   : %14.3f\n", servermeta.AnalysedLinesPerSecond)
 	fmt.Fprintf(w, "  Bytes/s   : %14.3f\n", servermeta.AnalysedBytesPerSecond)
 	fmt.Fprintf(w, "  Queries/s : %14.3f\n", servermeta.AnalysedQueriesPerSecond)
 
 	fmt.Fprintf(w, "\n# Global Statistics\n\n")
 	fmt.Fprintf(w, "  Total queries      : %.3fM (%d)\n", float64(servermeta.QueryCount)/1000000.0, servermeta.QueryCount)
-	fmt.Fprintf(w, "  Total bytes        : %.3fM (%d)\n", float64(servermeta.CumBytes)/1000000.0, servermeta.CumBytes)
+	if !servermeta.NoTiming {
+		fmt.Fprintf(w, "  Total bytes        : %.3fM (%d)\n", float64(servermeta.CumBytes)/1000000.0, servermeta.CumBytes)
+	}
 	fmt.Fprintf(w, "  Total fingerprints : %d\n", servermeta.UniqueQueries)
-	fmt.Fprintf(w, "  Capture start      : %s\n", servermeta.Start)
-	fmt.Fprintf(w, "  Capture end        : %s\n", servermeta.End)
-	fmt.Fprintf(w, "  Duration           : %s (%d s)\n", servermeta.End.Sub(servermeta.Start), servermeta.End.Sub(servermeta.Start)/time.Second)
-	fmt.Fprintf(w, "  QPS                : %.0f\n", float64(time.Second)*(float64(servermeta.QueryCount)/float64(servermeta.End.Sub(servermeta.Start))))
+	if servermeta.HasTimeRange() {
+		fmt.Fprintf(w, "  Capture start      : %s\n", servermeta.Start)
+		fmt.Fprintf(w, "  Capture end        : %s\n", servermeta.End)
+		fmt.Fprintf(w, "  Duration           : %s (%d s)\n", servermeta.End.Sub(servermeta.Start), servermeta.End.Sub(servermeta.Start)/time.Second)
+		fmt.Fprintf(w, "  QPS                : %.0f\n", float64(time.Second)*(float64(servermeta.QueryCount)/float64(servermeta.End.Sub(servermeta.Start))))
+	} else {
+		fmt.Fprintf(w, "  Capture range      : unknown\n")
+	}
+
+	if tx := servermeta.Transactions; tx != nil && tx.Count > 0 {
+		fmt.Fprintf(w, "\n# Transactions\n\n")
+		fmt.Fprintf(w, "  Count              : %d\n", tx.Count)
+		fmt.Fprintf(w, "  Rows (avg/max)     : %.1f / %d\n", float64(tx.CumRows)/float64(tx.Count), tx.MaxRows)
+		fmt.Fprintf(w, "  Bytes (avg/max)    : %.0f / %d\n", float64(tx.CumBytes)/float64(tx.Count), tx.MaxBytes)
+	}
+
+	if len(servermeta.Procedures) > 0 {
+		fmt.Fprintf(w, "\n# Procedures\n\n")
+		fmt.Fprintf(w, "  %-4s %-32s %8s %10s %12s\n", "Rank", "Name", "Calls", "Statements", "CumTime")
+		for idx, proc := range servermeta.Procedures {
+			fmt.Fprintf(w, "  %-4d %-32s %8d %10d %12s\n", idx+1, proc.Name, proc.Calls, proc.Statements, fsecsToDuration(proc.CumTime))
+		}
+	}
 
 	fmt.Fprintf(w, "\n# Queries\n")
 
-	ffactor := 100.0 * float64(time.Second) / float64(servermeta.End.Sub(servermeta.Start))
+	ffactor := 0.0
+	if servermeta.HasTimeRange() {
+		ffactor = 100.0 * float64(time.Second) / float64(servermeta.End.Sub(servermeta.Start))
+	}
 	for idx, val := range s {
 		val.Concurrency = val.CumQueryTime * ffactor
 		sort.Float64s(val.QueryTime)
 		fmt.Fprintf(w, "\n# Query #%d: %x\n\n", idx+1, val.Hash[0:5])
 		fmt.Fprintf(w, "  Fingerprint     : %s\n", val.FingerPrint)
 		fmt.Fprintf(w, "  Schema          : %s\n", val.Schema)
+		if val.Digest != "" {
+			fmt.Fprintf(w, "  Digest          : %s\n", val.Digest)
+		}
+		if val.Routine != "" {
+			fmt.Fprintf(w, "  Routine         : %s\n", val.Routine)
+		}
+		if val.Hostgroup != "" {
+			fmt.Fprintf(w, "  Hostgroup       : %s\n", val.Hostgroup)
+		}
+		if val.Backend != "" {
+			fmt.Fprintf(w, "  Backend         : %s\n", val.Backend)
+		}
 		fmt.Fprintf(w, "  Calls           : %d\n", val.Count)
 		fmt.Fprintf(w, "  CumErrored      : %d\n", val.CumErrored)
 		fmt.Fprintf(w, "  CumKilled       : %d\n", val.CumKilled)
-		fmt.Fprintf(w, "  CumQueryTime    : %s\n", fsecsToDuration(val.CumQueryTime))
-		fmt.Fprintf(w, "  CumLockTime     : %s\n", fsecsToDuration(val.CumLockTime))
-		fmt.Fprintf(w, "  CumRowsSent     : %d\n", val.CumRowsSent)
-		fmt.Fprintf(w, "  CumRowsExamined : %d\n", val.CumRowsExamined)
-		fmt.Fprintf(w, "  CumRowsAffected : %d\n", val.CumRowsAffected)
-		fmt.Fprintf(w, "  CumBytesSent    : %d\n", val.CumBytesSent)
-		fmt.Fprintf(w, "  Concurrency     : %2.2f%%\n", val.Concurrency)
-		fmt.Fprintf(w, "  min / max time  : %s / %s\n", fsecsToDuration(val.QueryTime[0]), fsecsToDuration(val.QueryTime[len(val.QueryTime)-1]))
-		fmt.Fprintf(w, "  mean time       : %s\n", fsecsToDuration(stat.Mean(val.QueryTime, nil)))
-		fmt.Fprintf(w, "  p50 time        : %s\n", fsecsToDuration(stat.Quantile(0.5, 1, val.QueryTime, nil)))
-		fmt.Fprintf(w, "  p95 time        : %s\n", fsecsToDuration(stat.Quantile(0.95, 1, val.QueryTime, nil)))
-		fmt.Fprintf(w, "  stddev time     : %s\n", fsecsToDuration(stat.StdDev(val.QueryTime, nil)))
+		// Time based metrics are not available for some inputs (e.g. general log)
+		if !val.NoTiming {
+			fmt.Fprintf(w, "  CumQueryTime    : %s\n", fsecsToDuration(val.CumQueryTime))
+			fmt.Fprintf(w, "  CumLockTime     : %s\n", fsecsToDuration(val.CumLockTime))
+			fmt.Fprintf(w, "  CumRowsSent     : %d\n", val.CumRowsSent)
+			fmt.Fprintf(w, "  CumRowsExamined : %d\n", val.CumRowsExamined)
+			fmt.Fprintf(w, "  CumRowsAffected : %d\n", val.CumRowsAffected)
+			fmt.Fprintf(w, "  CumBytesSent    : %d\n", val.CumBytesSent)
+			if servermeta.HasTimeRange() {
+				fmt.Fprintf(w, "  Concurrency     : %2.2f%%\n", val.Concurrency)
+			}
+		} else if val.CumRowsAffected > 0 {
+			// Row based binary log events
+			fmt.Fprintf(w, "  CumRowsAffected : %d\n", val.CumRowsAffected)
+		}
+		if sum := val.QueryTimeSummary; sum != nil {
+			// Pre-aggregated statistics (performance_schema digests)
+			fmt.Fprintf(w, "  min / max time  : %s / %s\n", fsecsToDuration(sum.Min), fsecsToDuration(sum.Max))
+			fmt.Fprintf(w, "  mean time       : %s\n", fsecsToDuration(sum.Mean))
+			if sum.P95 > 0 {
+				fmt.Fprintf(w, "  p95 time        : %s\n", fsecsToDuration(sum.P95))
+				fmt.Fprintf(w, "  p99 time        : %s\n", fsecsToDuration(sum.P99))
+				fmt.Fprintf(w, "  p99.9 time      : %s\n", fsecsToDuration(sum.P999))
+			}
+		} else if !val.NoTiming {
+			fmt.Fprintf(w, "  min / max time  : %s / %s\n", fsecsToDuration(val.QueryTime[0]), fsecsToDuration(val.QueryTime[len(val.QueryTime)-1]))
+			fmt.Fprintf(w, "  mean time       : %s\n", fsecsToDuration(stat.Mean(val.QueryTime, nil)))
+			fmt.Fprintf(w, "  p50 time        : %s\n", fsecsToDuration(stat.Quantile(0.5, 1, val.QueryTime, nil)))
+			fmt.Fprintf(w, "  p95 time        : %s\n", fsecsToDuration(stat.Quantile(0.95, 1, val.QueryTime, nil)))
+			fmt.Fprintf(w, "  stddev time     : %s\n", fsecsToDuration(stat.StdDev(val.QueryTime, nil)))
+		}
 		// fmt.Fprintf(w, "\tmax time        : %.2f\n", stat.Max(0.95, 1, val.QueryTime, nil))
 
+		// Percona extended statistics, only when available
+		if val.QueryPlanCount > 0 {
+			fmt.Fprintf(w, "  QC_Hit          : %.0f%%\n", val.QCHitPct)
+			fmt.Fprintf(w, "  Full_scan       : %.0f%%\n", val.FullScanPct)
+			fmt.Fprintf(w, "  Full_join       : %.0f%%\n", val.FullJoinPct)
+			fmt.Fprintf(w, "  Tmp_table       : %.0f%% (%.0f%% on disk)\n", val.TmpTablePct, val.TmpTableOnDiskPct)
+			fmt.Fprintf(w, "  Filesort        : %.0f%% (%.0f%% on disk)\n", val.FilesortPct, val.FilesortOnDiskPct)
+			fmt.Fprintf(w, "  CumTmpTables    : %d (%d on disk, %d bytes)\n", val.CumTmpTables, val.CumTmpDiskTables, val.CumTmpTableSizes)
+			fmt.Fprintf(w, "  CumMergePasses  : %d\n", val.CumMergePasses)
+		}
+
+		if val.InnoDBCount > 0 {
+			fmt.Fprintf(w, "  InnoDB IO reads : %d (%d bytes, %s)\n", val.CumInnoDBIOReadOps, val.CumInnoDBIOReadBytes, fsecsToDuration(val.CumInnoDBIOReadWait))
+			fmt.Fprintf(w, "  InnoDB lock wait: %s\n", fsecsToDuration(val.CumInnoDBRecLockWait))
+			fmt.Fprintf(w, "  InnoDB queue    : %s\n", fsecsToDuration(val.CumInnoDBQueueWait))
+			fmt.Fprintf(w, "  InnoDB pages    : %.0f mean / %.0f p95\n", stat.Mean(val.InnoDBPagesDistinct, nil), quantile(0.95, val.InnoDBPagesDistinct))
+		}
+
+		// Execution plan for the slowest sample, only when available
+		if val.Explain != nil {
+			for i, row := range val.Explain.Rows {
+				label := "  Explain         :"
+				if i > 0 {
+					label = "                   "
+				}
+				fmt.Fprintf(w, "%s %s\n", label, explainRow(val.Explain.Columns, row))
+			}
+		}
+
+		// Per-source breakdown, only when requested
+		if len(val.PerSource) > 0 {
+			fmt.Fprintf(w, "  Per source      :\n")
+			for _, src := range servermeta.Sources {
+				if st, ok := val.PerSource[src.Name]; ok {
+					fmt.Fprintf(w, "    %s: %d calls, %s\n", src.Name, st.Count, fsecsToDuration(st.CumQueryTime))
+				}
+			}
+		}
+
+		// MySQL 8.0 extra statistics, only when available
+		if val.SlowExtraCount > 0 {
+			fmt.Fprintf(w, "  CumBytesRecv    : %d\n", val.CumBytesReceived)
+			fmt.Fprintf(w, "  Handler reads   : first %d, last %d, key %d, next %d, prev %d, rnd %d, rnd_next %d\n",
+				val.CumReadFirst, val.CumReadLast, val.CumReadKey, val.CumReadNext, val.CumReadPrev, val.CumReadRnd, val.CumReadRndNext)
+			fmt.Fprintf(w, "  Sorts           : %d rows, %d range, %d scan, %d merge passes\n",
+				val.CumSortRows, val.CumSortRangeCount, val.CumSortScanCount, val.CumMergePasses)
+			fmt.Fprintf(w, "  CumTmpTables    : %d (%d on disk)\n", val.CumTmpTables, val.CumTmpDiskTables)
+		}
+
+		// TiDB statistics, only when available
+		if val.TiDBCount > 0 {
+			fmt.Fprintf(w, "  Parse / compile : %s / %s\n", fsecsToDuration(val.CumParseTime), fsecsToDuration(val.CumCompileTime))
+			fmt.Fprintf(w, "  Cop time        : %s (process %s, wait %s, %d tasks)\n",
+				fsecsToDuration(val.CumCopTime), fsecsToDuration(val.CumProcessTime), fsecsToDuration(val.CumWaitTime), val.CumCopTasks)
+			fmt.Fprintf(w, "  Backoff time    : %s\n", fsecsToDuration(val.CumBackoffTime))
+			fmt.Fprintf(w, "  Keys            : %d processed / %d total, %d written\n", val.CumProcessKeys, val.CumTotalKeys, val.CumWriteKeys)
+			fmt.Fprintf(w, "  Mem max         : %d bytes (%d mean)\n", val.MaxMemMax, val.CumMemMax/val.TiDBCount)
+			fmt.Fprintf(w, "  Disk max        : %d bytes\n", val.MaxDiskMax)
+		}
+
 	}
 
 }
 
+// explainRow formats an explain row as "column: value" pairs
+func explainRow(columns, row []string) string {
+	pairs := make([]string, 0, len(row))
+	for i, v := range row {
+		col := fmt.Sprintf("col%d", i+1)
+		if i < len(columns) {
+			col = columns[i]
+		}
+		pairs = append(pairs, col+": "+v)
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// quantile returns the p quantile of the unsorted values in x
+func quantile(p float64, x []float64) float64 {
+	sorted := append([]float64(nil), x...)
+	sort.Float64s(sorted)
+	return stat.Quantile(p, 1, sorted, nil)
+}
+
 // fsecsToDuration converts float seconds to time.Duration
 // Since we have float64 seconds durations
 // We first convert to µs (* 1e6) then to duration
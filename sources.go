@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// compressedExtensions holds extensions stripped when ordering rotated files
+var compressedExtensions = []string{".gz", ".bz2", ".xz", ".zst", ".zstd"}
+
+// expandSources expands arguments (files, globs & directories) into a list of
+// files ordered chronologically
+// With "auto" input format, directory files in no known format (e.g. error
+// logs) are skipped
+// "-" (STDIN) is only allowed alone; arguments expanding to no file are an
+// error
+func expandSources(args []string) ([]string, error) {
+	files := []string{}
+	seen := map[string]bool{}
+
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			files = append(files, name)
+		}
+	}
+
+	for _, arg := range args {
+		if arg == "-" {
+			if len(args) > 1 {
+				return nil, fmt.Errorf("STDIN can not be mixed with files")
+			}
+			return []string{arg}, nil
+		}
+
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %s: %v", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no file matching %s", arg)
+			}
+			for _, m := range matches {
+				add(m)
+			}
+			continue
+		}
+
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fi.IsDir() {
+			add(arg)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			// Skip our own cache files
+			if !e.Mode().IsRegular() || strings.HasSuffix(e.Name(), ".cache") {
+				continue
+			}
+
+			name := filepath.Join(arg, e.Name())
+			if Config.InputFormat == "auto" && !recognizedFile(name) {
+				log.Warnf("skipping %s: unknown input format", name)
+				continue
+			}
+			add(name)
+		}
+	}
+
+	// Empty directories, or holding no file in a known format
+	if len(args) > 0 && len(files) == 0 {
+		return nil, fmt.Errorf("no file to read in %s", strings.Join(args, ", "))
+	}
+
+	sortRotated(files)
+
+	return files, nil
+}
+
+// dateextre matches logrotate dateext suffixes (e.g. "-20240101",
+// "-2024-01-01" or "-20240101-1704067200")
+var dateextre = regexp.MustCompile(`^(.+)-(\d{4}-?\d{2}-?\d{2}(?:[-_]?\d+)?)$`)
+
+// rotation holds how a file fits in a rotated set
+type rotation struct {
+	base  string
+	index int    // rotation index (e.g. 2 for "slow.log.2.gz")
+	date  string // logrotate dateext date, digits only (e.g. "20240101")
+}
+
+// current returns true for files that are not rotated (e.g. "slow.log")
+func (r rotation) current() bool {
+	return r.index == 0 && r.date == ""
+}
+
+// rotationKey splits a rotated log file name into its base name and rotation
+// index (e.g. "slow.log.2.gz" -> "slow.log", 2) or logrotate dateext date
+// (e.g. "slow.log-20240101.gz" -> "slow.log", "20240101")
+func rotationKey(name string) rotation {
+	base := name
+	for _, ext := range compressedExtensions {
+		if strings.HasSuffix(base, ext) {
+			base = strings.TrimSuffix(base, ext)
+			break
+		}
+	}
+
+	ext := filepath.Ext(base)
+	if n, err := strconv.Atoi(strings.TrimPrefix(ext, ".")); err == nil && ext != "" {
+		return rotation{base: strings.TrimSuffix(base, ext), index: n}
+	}
+
+	if m := dateextre.FindStringSubmatch(base); m != nil {
+		date := strings.NewReplacer("-", "", "_", "").Replace(m[2])
+		return rotation{base: m[1], date: date}
+	}
+
+	return rotation{base: base}
+}
+
+// sortRotated sorts files so rotated sets are ordered chronologically, i.e.
+// "slow.log.2.gz", "slow.log.1", "slow.log" or "slow.log-20240101.gz",
+// "slow.log-20240102", "slow.log"
+func sortRotated(files []string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		ri := rotationKey(files[i])
+		rj := rotationKey(files[j])
+
+		if ri.base != rj.base {
+			return ri.base < rj.base
+		}
+
+		// Current file comes last
+		if ri.current() || rj.current() {
+			return rj.current() && !ri.current()
+		}
+
+		// Dated files: oldest date first
+		if ri.date != "" && rj.date != "" {
+			return ri.date < rj.date
+		}
+
+		// Numbered files: older (higher index) first
+		if ri.date == "" && rj.date == "" {
+			return ri.index > rj.index
+		}
+
+		// Dated & numbered files should not be mixed; dated ones go first
+		return ri.date != ""
+	})
+}